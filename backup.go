@@ -0,0 +1,382 @@
+package mdb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Backups are built from a copy of the environment that keeps the page
+// numbers of the data file. The pages of LMDB 0.9 do not record the
+// transaction that wrote them, so an incremental backup cannot select the
+// pages written since its base by their headers. Instead it compares a
+// checksum of every page with the manifest of the base backup: every backup
+// reads the whole environment, but only changed pages are written. The
+// manifest holds an 8-byte checksum per page and is streamed, so neither the
+// environment nor the manifest is held in memory.
+//
+// A backup stream is a header, the changed pages, each preceded by its page
+// number, an end marker and the SHA-256 checksum of the restored data file.
+// A manifest is a header followed by the CRC-64 checksums of the pages.
+
+var (
+	backupMagic   = []byte("GOMDBBAK")
+	manifestMagic = []byte("GOMDBMAN")
+)
+
+const backupEnd = ^uint64(0)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// BackupMismatch is returned by RestoreBackup if an incremental backup
+// does not follow the restored data file.
+var BackupMismatch = errors.New("Backup does not follow the restored data file")
+
+var errInvalidManifest = errors.New("Invalid backup manifest")
+
+// BackupInfo describes a backup written by Backup or BackupIncremental.
+type BackupInfo struct {
+	TxnID    uint64 // ID of the last transaction in the backup.
+	PageSize uint32 // Page size of the environment.
+	Pages    uint64 // Number of pages of the environment.
+	Written  uint64 // Number of pages written to the backup.
+}
+
+// manifestHeader is the header of a manifest.
+type manifestHeader struct {
+	pageSize uint32
+	txnid    uint64
+	pages    uint64
+}
+
+func readManifestHeader(r io.Reader) (*manifestHeader, error) {
+	hdr := make([]byte, len(manifestMagic)+20)
+	_, err := io.ReadFull(r, hdr)
+	if err != nil || !bytes.Equal(hdr[:len(manifestMagic)], manifestMagic) {
+		return nil, errInvalidManifest
+	}
+	hdr = hdr[len(manifestMagic):]
+	return &manifestHeader{
+		pageSize: binary.BigEndian.Uint32(hdr),
+		txnid:    binary.BigEndian.Uint64(hdr[4:]),
+		pages:    binary.BigEndian.Uint64(hdr[12:]),
+	}, nil
+}
+
+func (h *manifestHeader) write(w io.Writer) error {
+	hdr := make([]byte, len(manifestMagic)+20)
+	n := copy(hdr, manifestMagic)
+	binary.BigEndian.PutUint32(hdr[n:], h.pageSize)
+	binary.BigEndian.PutUint64(hdr[n+4:], h.txnid)
+	binary.BigEndian.PutUint64(hdr[n+12:], h.pages)
+	_, err := w.Write(hdr)
+	return err
+}
+
+// Backup writes a full backup of the environment to w and its manifest to
+// manifest.
+func (env *Env) Backup(w, manifest io.Writer) (*BackupInfo, error) {
+	return env.BackupIncremental(w, nil, manifest)
+}
+
+// BackupIncremental writes the pages that changed since the backup whose
+// manifest is read from base to w, and the manifest of the new backup to
+// manifest. If base is nil a full backup is written. The whole environment
+// is read even if few pages changed.
+func (env *Env) BackupIncremental(w io.Writer, base io.Reader, manifest io.Writer) (*BackupInfo, error) {
+	err := checkLayout()
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := env.CopyTo(pw, false)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	info, err := writeBackup(pr, w, base, manifest)
+	// Stop the copy if the backup failed before its end.
+	pr.CloseWithError(errors.New("Backup aborted"))
+	cerr := <-done
+	if err != nil {
+		return nil, err
+	}
+	if cerr != nil {
+		return nil, cerr
+	}
+	return info, nil
+}
+
+// metaPage returns the transaction ID and the last page number of a meta
+// page.
+func metaPage(p []byte) (txnid, lastPg uint64, err error) {
+	err = checkMeta(p)
+	if err != nil {
+		return 0, 0, err
+	}
+	return nativeEndian.Uint64(p[metaTxnIDOff:]), nativeEndian.Uint64(p[metaLastPgOff:]), nil
+}
+
+// currentMeta returns the transaction ID and the number of pages of the
+// newer of the two meta pages.
+func currentMeta(meta0, meta1 []byte) (txnid, npages uint64, err error) {
+	txnid, lastPg, err := metaPage(meta0)
+	if err != nil {
+		return 0, 0, err
+	}
+	txnid1, lastPg1, err := metaPage(meta1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if txnid1 > txnid {
+		txnid, lastPg = txnid1, lastPg1
+	}
+	return txnid, lastPg + 1, nil
+}
+
+// writeBackup reads a copy of the data file from r and writes the pages
+// that differ from the manifest read from base to w.
+func writeBackup(r io.Reader, w io.Writer, base io.Reader, manifest io.Writer) (*BackupInfo, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	p, err := br.Peek(metaSize)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	err = checkMeta(p)
+	if err != nil {
+		return nil, err
+	}
+	psize := nativeEndian.Uint32(p[metaPSizeOff:])
+	if psize < metaSize {
+		return nil, errDataFormat
+	}
+	metas := make([]byte, 2*psize)
+	_, err = io.ReadFull(br, metas)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	txnid, npages, err := currentMeta(metas[:psize], metas[psize:])
+	if err != nil {
+		return nil, err
+	}
+	var baseHdr *manifestHeader
+	var baseR *bufio.Reader
+	if base != nil {
+		baseR = bufio.NewReaderSize(base, 1<<16)
+		baseHdr, err = readManifestHeader(baseR)
+		if err != nil {
+			return nil, err
+		}
+		if baseHdr.pageSize != psize {
+			return nil, errors.New("Page size differs from the base backup")
+		}
+		if baseHdr.txnid > txnid {
+			return nil, errors.New("Base backup is newer than the environment")
+		}
+	}
+	info := &BackupInfo{TxnID: txnid, PageSize: psize, Pages: npages}
+	mw := bufio.NewWriterSize(manifest, 1<<16)
+	err = (&manifestHeader{pageSize: psize, txnid: txnid, pages: npages}).write(mw)
+	if err != nil {
+		return nil, err
+	}
+
+	bw := bufio.NewWriterSize(w, 1<<16)
+	hdr := make([]byte, len(backupMagic)+28)
+	n := copy(hdr, backupMagic)
+	binary.BigEndian.PutUint32(hdr[n:], psize)
+	if baseHdr != nil {
+		binary.BigEndian.PutUint64(hdr[n+4:], baseHdr.txnid)
+	}
+	binary.BigEndian.PutUint64(hdr[n+12:], txnid)
+	binary.BigEndian.PutUint64(hdr[n+20:], npages)
+	bw.Write(hdr)
+
+	sum := sha256.New()
+	page := make([]byte, psize)
+	var pgno, crc, baseCRC [8]byte
+	for i := uint64(0); i < npages; i++ {
+		if i < 2 {
+			copy(page, metas[uint64(psize)*i:])
+		} else {
+			_, err = io.ReadFull(br, page)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+		}
+		sum.Write(page)
+		binary.BigEndian.PutUint64(crc[:], crc64.Checksum(page, crcTable))
+		_, err = mw.Write(crc[:])
+		if err != nil {
+			return nil, err
+		}
+		// The checksums of the base are read along with the pages, so a
+		// manifest claiming more pages than it holds fails here.
+		unchanged := false
+		if baseHdr != nil && i < baseHdr.pages {
+			_, err = io.ReadFull(baseR, baseCRC[:])
+			if err != nil {
+				return nil, errInvalidManifest
+			}
+			unchanged = baseCRC == crc
+		}
+		// The meta pages are always written since they identify the
+		// transaction of the backup.
+		if i >= 2 && unchanged {
+			continue
+		}
+		binary.BigEndian.PutUint64(pgno[:], i)
+		bw.Write(pgno[:])
+		_, err = bw.Write(page)
+		if err != nil {
+			return nil, err
+		}
+		info.Written++
+	}
+	// Wait for the end of the copy.
+	_, err = io.Copy(ioutil.Discard, br)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint64(pgno[:], backupEnd)
+	bw.Write(pgno[:])
+	bw.Write(sum.Sum(nil))
+	err = bw.Flush()
+	if err != nil {
+		return nil, err
+	}
+	err = mw.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// RestoreBackup applies the backup read from r to the data file at path
+// and verifies the checksum of the result. A full backup creates the data
+// file; incremental backups must then be applied in the order they were
+// made, otherwise BackupMismatch is returned. A failed restore may leave
+// the data file unusable, so restore into a scratch file and move it into
+// place once all backups are applied.
+func RestoreBackup(path string, r io.Reader) error {
+	br := bufio.NewReaderSize(r, 1<<16)
+	hdr := make([]byte, len(backupMagic)+28)
+	_, err := io.ReadFull(br, hdr)
+	if err != nil || !bytes.Equal(hdr[:len(backupMagic)], backupMagic) {
+		return errors.New("Invalid backup")
+	}
+	hdr = hdr[len(backupMagic):]
+	psize := binary.BigEndian.Uint32(hdr)
+	baseTxnID := binary.BigEndian.Uint64(hdr[4:])
+	txnid := binary.BigEndian.Uint64(hdr[12:])
+	npages := binary.BigEndian.Uint64(hdr[20:])
+	if psize < metaSize {
+		return errors.New("Invalid backup")
+	}
+
+	flags := os.O_RDWR
+	if baseTxnID == 0 {
+		flags |= os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if baseTxnID != 0 {
+		metas := make([]byte, 2*psize)
+		_, err = f.ReadAt(metas, 0)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		current, _, err := currentMeta(metas[:psize], metas[psize:])
+		if err != nil {
+			return err
+		}
+		if current != baseTxnID || nativeEndian.Uint32(metas[metaPSizeOff:]) != psize {
+			return BackupMismatch
+		}
+	}
+	err = f.Truncate(int64(npages) * int64(psize))
+	if err != nil {
+		return err
+	}
+
+	page := make([]byte, psize)
+	var pgno [8]byte
+	for {
+		_, err = io.ReadFull(br, pgno[:])
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		n := binary.BigEndian.Uint64(pgno[:])
+		if n == backupEnd {
+			break
+		}
+		if n >= npages {
+			return errors.New("Invalid backup")
+		}
+		_, err = io.ReadFull(br, page)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		_, err = f.WriteAt(page, int64(n)*int64(psize))
+		if err != nil {
+			return err
+		}
+	}
+	var want [sha256.Size]byte
+	_, err = io.ReadFull(br, want[:])
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	return verifyBackup(f, psize, txnid, want[:])
+}
+
+// verifyBackup checks the SHA-256 checksum and the transaction ID of a
+// restored data file.
+func verifyBackup(f *os.File, psize uint32, txnid uint64, want []byte) error {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	sum := sha256.New()
+	_, err = io.Copy(sum, f)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum.Sum(nil), want) {
+		return errors.New("Checksum of the restored data file does not match the backup")
+	}
+	metas := make([]byte, 2*psize)
+	_, err = f.ReadAt(metas, 0)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	current, _, err := currentMeta(metas[:psize], metas[psize:])
+	if err != nil {
+		return err
+	}
+	if current != txnid {
+		return errors.New("Restored data file has an unexpected transaction ID")
+	}
+	return nil
+}
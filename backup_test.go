@@ -0,0 +1,157 @@
+package mdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func putRange(t *testing.T, env *Env, from, to int) {
+	err := env.Update(func(txn *Txn) error {
+		dbi, err := txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		for i := from; i < to; i++ {
+			err = txn.Put(dbi, []byte(fmt.Sprintf("key%05d", i)), bytes.Repeat([]byte{byte(i)}, 100), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot put: %s", err)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	putRange(t, env, 0, 2000)
+
+	var full, manifest bytes.Buffer
+	info, err := env.Backup(&full, &manifest)
+	if err != nil {
+		t.Fatalf("Cannot back up: %s", err)
+	}
+	if info.Written != info.Pages {
+		t.Errorf("Full backup wrote %d of %d pages", info.Written, info.Pages)
+	}
+
+	putRange(t, env, 1000, 1010)
+	var inc, manifest2 bytes.Buffer
+	info2, err := env.BackupIncremental(&inc, bytes.NewReader(manifest.Bytes()), &manifest2)
+	if err != nil {
+		t.Fatalf("Cannot back up incrementally: %s", err)
+	}
+	if info2.TxnID <= info.TxnID {
+		t.Errorf("TxnID %d not after %d", info2.TxnID, info.TxnID)
+	}
+	if inc.Len() >= full.Len()/4 || info2.Written >= info2.Pages/4 {
+		t.Errorf("Incremental backup of %d bytes, full %d", inc.Len(), full.Len())
+	}
+	if manifest2.Len() != len(manifestMagic)+20+8*int(info2.Pages) {
+		t.Errorf("Manifest of %d bytes for %d pages", manifest2.Len(), info2.Pages)
+	}
+	// A manifest claiming more pages than it holds is rejected.
+	bad := append([]byte(nil), manifest.Bytes()...)
+	binary.BigEndian.PutUint64(bad[len(manifestMagic)+12:], 1<<62)
+	_, err = env.BackupIncremental(ioutil.Discard, bytes.NewReader(bad), ioutil.Discard)
+	if err != errInvalidManifest {
+		t.Errorf("Backup with a truncated manifest: %v", err)
+	}
+	_, err = env.BackupIncremental(ioutil.Discard, bytes.NewReader(bad[:10]), ioutil.Discard)
+	if err != errInvalidManifest {
+		t.Errorf("Backup with an invalid manifest: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("/tmp", "mdb_test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data.mdb")
+	err = RestoreBackup(path, bytes.NewReader(inc.Bytes()))
+	if err == nil {
+		t.Errorf("Incremental backup restored without base")
+	}
+	err = RestoreBackup(path, bytes.NewReader(full.Bytes()))
+	if err != nil {
+		t.Fatalf("Cannot restore full backup: %s", err)
+	}
+	corrupt := append([]byte(nil), inc.Bytes()...)
+	corrupt[len(corrupt)-100] ^= 1
+	err = RestoreBackup(path, bytes.NewReader(corrupt))
+	if err == nil {
+		t.Errorf("Corrupt backup restored")
+	}
+	err = RestoreBackup(path, bytes.NewReader(full.Bytes()))
+	if err != nil {
+		t.Fatalf("Cannot restore full backup: %s", err)
+	}
+	err = RestoreBackup(path, bytes.NewReader(inc.Bytes()))
+	if err != nil {
+		t.Fatalf("Cannot restore incremental backup: %s", err)
+	}
+	err = RestoreBackup(path, bytes.NewReader(inc.Bytes()))
+	if err != BackupMismatch {
+		t.Errorf("Incremental backup restored twice: %v", err)
+	}
+
+	restored, err := NewEnv()
+	if err != nil {
+		t.Fatalf("Cannot create environment: %s", err)
+	}
+	err = restored.Open(dir, 0, 0664)
+	if err != nil {
+		t.Fatalf("Cannot open restored environment: %s", err)
+	}
+	defer restored.Close()
+	err = restored.View(func(txn *Txn) error {
+		dbi, err := txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		stat, err := txn.Stat(dbi)
+		if err != nil {
+			return err
+		}
+		if stat.Entries != 2000 {
+			t.Errorf("%d entries", stat.Entries)
+		}
+		val, err := txn.Get(dbi, []byte("key01005"))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(val, bytes.Repeat([]byte{byte(1005 & 0xff)}, 100)) {
+			t.Errorf("Wrong value %v", val)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot read restored environment: %s", err)
+	}
+}
+
+func TestCheckMeta(t *testing.T) {
+	p := make([]byte, metaSize)
+	nativeEndian.PutUint32(p[metaMagicOff:], metaMagic)
+	if err := checkMeta(p); err != nil {
+		t.Errorf("Valid meta page rejected: %s", err)
+	}
+	// A data file of a host of the other byte order.
+	for i, j := metaMagicOff, metaMagicOff+3; i < j; i, j = i+1, j-1 {
+		p[i], p[j] = p[j], p[i]
+	}
+	if err := checkMeta(p); err == nil || err == errDataFormat {
+		t.Errorf("Meta page of the other byte order: %v", err)
+	}
+	if err := checkMeta(p[:10]); err != errDataFormat {
+		t.Errorf("Short meta page: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package mdb
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// BatchOptions controls how Env.Batch groups operations into transactions.
+type BatchOptions struct {
+	MaxSize  int           // Maximum number of operations per transaction.
+	MaxDelay time.Duration // Maximum time to wait for more operations before committing.
+}
+
+// DefaultBatchOptions are used by Env.Batch unless SetBatchOptions is called.
+var DefaultBatchOptions = BatchOptions{MaxSize: 1000, MaxDelay: 10 * time.Millisecond}
+
+// SetBatchOptions sets the options used by Batch. It must be called before
+// the first call to Batch.
+func (env *Env) SetBatchOptions(opts BatchOptions) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.batchOpts = opts
+}
+
+// Batch runs op in a read-write transaction shared with the operations of
+// other goroutines calling Batch. All transactions are run by a single
+// goroutine locked to its own OS thread, which commits once per batch.
+//
+// If an operation returns an error or panics, the transaction is aborted and
+// the other operations of the batch are run again in a new transaction, so
+// op may be run more than once and must not have side effects outside of the
+// transaction. The error of op, or the error of the commit, is returned.
+func (env *Env) Batch(op TxnOp) error {
+	b := env.startBatcher()
+	if b == nil {
+		return errors.New("Environment closed")
+	}
+	call := &batchCall{op: op, err: make(chan error, 1)}
+	select {
+	case b.calls <- call:
+	case <-b.stop:
+		return errors.New("Environment closed")
+	}
+	return <-call.err
+}
+
+type batchCall struct {
+	op  TxnOp
+	err chan error
+}
+
+type batcher struct {
+	env   *Env
+	opts  BatchOptions
+	calls chan *batchCall
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func (env *Env) startBatcher() *batcher {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.closing {
+		return nil
+	}
+	if env.batcher == nil {
+		opts := env.batchOpts
+		if opts.MaxSize <= 0 {
+			opts.MaxSize = DefaultBatchOptions.MaxSize
+		}
+		if opts.MaxDelay <= 0 {
+			opts.MaxDelay = DefaultBatchOptions.MaxDelay
+		}
+		env.batcher = &batcher{
+			env:   env,
+			opts:  opts,
+			calls: make(chan *batchCall),
+			stop:  make(chan struct{}),
+			done:  make(chan struct{}),
+		}
+		go env.batcher.loop()
+	}
+	return env.batcher
+}
+
+// stopBatcher waits for the running batch to finish and stops the batcher.
+// No batcher is started afterwards.
+func (env *Env) stopBatcher() {
+	env.mu.Lock()
+	env.closing = true
+	b := env.batcher
+	env.batcher = nil
+	env.mu.Unlock()
+	if b != nil {
+		close(b.stop)
+		<-b.done
+	}
+}
+
+func (b *batcher) loop() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer close(b.done)
+	for {
+		var calls []*batchCall
+		select {
+		case call := <-b.calls:
+			calls = append(calls, call)
+		case <-b.stop:
+			return
+		}
+		timer := time.NewTimer(b.opts.MaxDelay)
+	collect:
+		for len(calls) < b.opts.MaxSize {
+			select {
+			case call := <-b.calls:
+				calls = append(calls, call)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		b.run(calls)
+	}
+}
+
+// run commits calls in one transaction. A failing call is answered with its
+// error and the others are run again.
+func (b *batcher) run(calls []*batchCall) {
+	for len(calls) > 0 {
+		failed := -1
+		err := b.env.Update(func(txn *Txn) error {
+			failed = -1
+			for i, call := range calls {
+				err := call.run(txn)
+				if err != nil {
+					failed = i
+					return err
+				}
+			}
+			return nil
+		})
+		if failed < 0 {
+			for _, call := range calls {
+				call.err <- err
+			}
+			return
+		}
+		calls[failed].err <- err
+		calls = append(calls[:failed], calls[failed+1:]...)
+	}
+}
+
+func (call *batchCall) run(txn *Txn) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("Batch operation panicked: %v", e)
+		}
+	}()
+	return call.op(txn)
+}
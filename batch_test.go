@@ -0,0 +1,108 @@
+package mdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	env.SetBatchOptions(BatchOptions{MaxSize: 100, MaxDelay: 50 * time.Millisecond})
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.DBIOpen(nil, 0)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Cannot open DBI: %s", err)
+	}
+	before, err := env.Info()
+	if err != nil {
+		t.Fatalf("Cannot get info: %s", err)
+	}
+
+	const n = 50
+	errFail := errors.New("fail")
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = env.Batch(func(txn *Txn) error {
+				key := []byte(fmt.Sprintf("Key-%02d", i))
+				err := txn.Put(dbi, key, []byte("val"), 0)
+				if err != nil {
+					return err
+				}
+				switch i {
+				case 7:
+					return errFail
+				case 13:
+					panic("boom")
+				}
+				return nil
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		switch i {
+		case 7:
+			if err != errFail {
+				t.Errorf("Call %d returned %v, expected %v", i, err, errFail)
+			}
+		case 13:
+			if err == nil {
+				t.Errorf("Call %d panicked but returned no error", i)
+			}
+		default:
+			if err != nil {
+				t.Errorf("Call %d failed: %s", i, err)
+			}
+		}
+	}
+	after, err := env.Info()
+	if err != nil {
+		t.Fatalf("Cannot get info: %s", err)
+	}
+	if commits := after.LastTxnID - before.LastTxnID; commits >= n {
+		t.Errorf("Calls were not batched: %d commits", commits)
+	}
+	err = env.View(func(txn *Txn) error {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("Key-%02d", i))
+			_, err := txn.Get(dbi, key)
+			if i == 7 || i == 13 {
+				if err != NotFound {
+					t.Errorf("Failed call %d was committed", i)
+				}
+			} else if err != nil {
+				t.Errorf("Call %d was not committed: %v", i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestBatchClosed(t *testing.T) {
+	env := setup(t)
+	err := env.Batch(func(txn *Txn) error { return nil })
+	if err != nil {
+		t.Fatalf("Batch: %s", err)
+	}
+	clean(env, t)
+	err = env.Batch(func(txn *Txn) error { return nil })
+	if err == nil {
+		t.Errorf("Batch on a closed environment succeeded")
+	}
+}
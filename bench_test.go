@@ -2,6 +2,7 @@ package mdb
 
 import (
 	crand "crypto/rand"
+	"encoding/binary"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -83,6 +84,46 @@ func BenchmarkTxnGetRDONLY(b *testing.B) {
 	b.StopTimer()
 }
 
+// like BenchmarkTxnGetRDONLY, but 100 keys are looked up with each txn.GetMany() call.
+func BenchmarkTxnGetManyRDONLY(b *testing.B) {
+	initRandSource(b)
+	env, path := setupBenchDB(b)
+	defer teardownBenchDB(b, env, path)
+
+	dbi := openBenchDBI(b, env)
+
+	var ps [][]byte
+
+	rc := newRandSourceCursor()
+	txn, err := env.BeginTxn(nil, 0)
+	bMust(b, err, "starting transaction")
+	for i := 0; i < benchDBNumKeys; i++ {
+		k := makeBenchDBKey(&rc)
+		v := makeBenchDBVal(&rc)
+		err := txn.Put(dbi, k, v, 0)
+		ps = append(ps, k, v)
+		bTxnMust(b, txn, err, "putting data")
+	}
+	err = txn.Commit()
+	bMust(b, err, "commiting transaction")
+
+	txn, err = env.BeginTxn(nil, RDONLY)
+	bMust(b, err, "starting transaction")
+	defer txn.Abort()
+	keys := make([][]byte, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += len(keys) {
+		for j := range keys {
+			keys[j] = ps[rand.Intn(len(ps))]
+		}
+		_, err := txn.GetMany(dbi, keys)
+		if err != nil {
+			b.Fatalf("error getting data: %v", err)
+		}
+	}
+	b.StopTimer()
+}
+
 // like BenchmarkTxnGetRDONLY, but txn.GetVal() is called instead.
 func BenchmarkTxnGetValRDONLY(b *testing.B) {
 	initRandSource(b)
@@ -122,6 +163,83 @@ func BenchmarkTxnGetValRDONLY(b *testing.B) {
 	b.StopTimer()
 }
 
+// repeatedly begin a read-only transaction and get a key.
+func BenchmarkBeginTxnRDONLY(b *testing.B) {
+	env, path := setupBenchDB(b)
+	defer teardownBenchDB(b, env, path)
+
+	dbi := openBenchDBI(b, env)
+	key := []byte("key")
+	err := env.Update(func(txn *Txn) error {
+		return txn.Put(dbi, key, []byte("val"), 0)
+	})
+	bMust(b, err, "putting data")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		txn, err := env.BeginTxn(nil, RDONLY)
+		bMust(b, err, "starting transaction")
+		_, err = txn.GetVal(dbi, key)
+		bTxnMust(b, txn, err, "getting data")
+		txn.Abort()
+	}
+	b.StopTimer()
+}
+
+// like BenchmarkBeginTxnRDONLY, but transactions are taken from a ReadTxnPool.
+func BenchmarkReadTxnPool(b *testing.B) {
+	env, path := setupBenchDB(b)
+	defer teardownBenchDB(b, env, path)
+
+	dbi := openBenchDBI(b, env)
+	key := []byte("key")
+	err := env.Update(func(txn *Txn) error {
+		return txn.Put(dbi, key, []byte("val"), 0)
+	})
+	bMust(b, err, "putting data")
+	pool, err := env.NewReadTxnPool(0)
+	bMust(b, err, "creating pool")
+	defer pool.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		txn, err := pool.Get()
+		bMust(b, err, "getting transaction")
+		_, err = txn.GetVal(dbi, key)
+		bMust(b, err, "getting data")
+		bMust(b, pool.Put(txn), "putting transaction")
+	}
+	b.StopTimer()
+}
+
+// like BenchmarkReadTxnPool, but the key is read with a pooled cursor.
+func BenchmarkReadTxnPoolCursor(b *testing.B) {
+	env, path := setupBenchDB(b)
+	defer teardownBenchDB(b, env, path)
+
+	dbi := openBenchDBI(b, env)
+	key := []byte("key")
+	err := env.Update(func(txn *Txn) error {
+		return txn.Put(dbi, key, []byte("val"), 0)
+	})
+	bMust(b, err, "putting data")
+	pool, err := env.NewReadTxnPool(0)
+	bMust(b, err, "creating pool")
+	defer pool.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		txn, err := pool.Get()
+		bMust(b, err, "getting transaction")
+		cur, err := pool.Cursor(txn, dbi)
+		bMust(b, err, "getting cursor")
+		_, _, err = cur.GetVal(key, nil, SET_KEY)
+		bMust(b, err, "getting data")
+		bMust(b, pool.Put(txn), "putting transaction")
+	}
+	b.StopTimer()
+}
+
 // repeatedly scan all the values in a database.
 func BenchmarkCursorScanRDONLY(b *testing.B) {
 	initRandSource(b)
@@ -224,6 +342,95 @@ func BenchmarkCursorScanValRDONLY(b *testing.B) {
 	b.StopTimer()
 }
 
+// repeatedly read all the duplicates of a DUPFIXED key one page at a time.
+func BenchmarkCursorGetMultipleRDONLY(b *testing.B) {
+	env, path := setupBenchDB(b)
+	defer teardownBenchDB(b, env, path)
+
+	key := []byte("dups")
+	dbi := openBenchDupFixedDBI(b, env, key)
+
+	txn, err := env.BeginTxn(nil, RDONLY)
+	bMust(b, err, "starting transaction")
+	defer txn.Abort()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		func() {
+			cur, err := txn.CursorOpen(dbi)
+			bMust(b, err, "opening cursor")
+			defer cur.Close()
+			m, err := cur.GetMultiple(key)
+			count := 0
+			for err == nil {
+				count += m.Len()
+				m, err = cur.NextMultiple()
+			}
+			if err != NotFound {
+				b.Fatalf("error getting data: %v", err)
+			}
+			if count != benchDBNumKeys {
+				b.Fatalf("unexpected number of values: %d", count)
+			}
+		}()
+	}
+	b.StopTimer()
+}
+
+// like BenchmarkCursorGetMultipleRDONLY, but one duplicate is read at a time.
+func BenchmarkCursorNextDupRDONLY(b *testing.B) {
+	env, path := setupBenchDB(b)
+	defer teardownBenchDB(b, env, path)
+
+	key := []byte("dups")
+	dbi := openBenchDupFixedDBI(b, env, key)
+
+	txn, err := env.BeginTxn(nil, RDONLY)
+	bMust(b, err, "starting transaction")
+	defer txn.Abort()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		func() {
+			cur, err := txn.CursorOpen(dbi)
+			bMust(b, err, "opening cursor")
+			defer cur.Close()
+			_, _, err = cur.Get(key, nil, SET_KEY)
+			count := 0
+			for err == nil {
+				count++
+				_, _, err = cur.Get(nil, nil, NEXT_DUP)
+			}
+			if err != NotFound {
+				b.Fatalf("error getting data: %v", err)
+			}
+			if count != benchDBNumKeys {
+				b.Fatalf("unexpected number of values: %d", count)
+			}
+		}()
+	}
+	b.StopTimer()
+}
+
+// open a DUPFIXED database holding benchDBNumKeys 8-byte duplicates of key.
+func openBenchDupFixedDBI(b *testing.B, env *Env, key []byte) DBI {
+	txn, err := env.BeginTxn(nil, 0)
+	bMust(b, err, "starting transaction")
+	name := "benchmark-dupfixed"
+	dbi, err := txn.DBIOpen(&name, CREATE|DUPSORT|DUPFIXED)
+	if err != nil {
+		txn.Abort()
+		b.Fatalf("error opening dbi: %v", err)
+	}
+	val := make([]byte, 8)
+	for i := 0; i < benchDBNumKeys; i++ {
+		binary.BigEndian.PutUint64(val, uint64(i))
+		err := txn.Put(dbi, key, val, APPENDDUP)
+		bTxnMust(b, txn, err, "putting data")
+	}
+	err = txn.Commit()
+	bMust(b, err, "commiting transaction")
+	return dbi
+}
+
 func setupBenchDB(b *testing.B) (*Env, string) {
 	env, err := NewEnv()
 	bMust(b, err, "creating env")
@@ -0,0 +1,114 @@
+//go:build go1.18
+
+package mdb
+
+import (
+	"errors"
+)
+
+// Bucket is a named database whose keys and values are converted with
+// codecs. Keys are encoded with a KeyCodec and the database uses the default
+// key comparison, so iteration visits keys in the natural order of K.
+type Bucket[K, V any] struct {
+	dbi  DBI
+	keys KeyCodec[K]
+	vals Codec[V]
+}
+
+// OpenBucket opens the named database name as a Bucket. flags are passed to
+// DBIOpen and must not change the key order, so REVERSEKEY and INTEGERKEY
+// are rejected. The Bucket may be used in later transactions as long as the
+// DBI stays open.
+func OpenBucket[K, V any](txn *Txn, name string, flags uint, keys KeyCodec[K], vals Codec[V]) (*Bucket[K, V], error) {
+	if flags&(REVERSEKEY|INTEGERKEY) != 0 {
+		return nil, errors.New("Bucket keys must use the default order")
+	}
+	dbi, err := txn.DBIOpen(&name, flags)
+	if err != nil {
+		return nil, err
+	}
+	return &Bucket[K, V]{dbi: dbi, keys: keys, vals: vals}, nil
+}
+
+// DBI returns the database handle of the bucket.
+func (b *Bucket[K, V]) DBI() DBI {
+	return b.dbi
+}
+
+// Get returns the value stored for key. NotFound is returned if there is
+// none.
+func (b *Bucket[K, V]) Get(txn *Txn, key K) (V, error) {
+	var v V
+	k, err := b.keys.Encode(key)
+	if err != nil {
+		return v, err
+	}
+	val, err := txn.GetVal(b.dbi, k)
+	if err != nil {
+		return v, err
+	}
+	return b.vals.Decode(val.Bytes())
+}
+
+// Put stores val for key. flags are passed to Txn.Put.
+func (b *Bucket[K, V]) Put(txn *Txn, key K, val V, flags uint) error {
+	k, err := b.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	v, err := b.vals.Encode(val)
+	if err != nil {
+		return err
+	}
+	return txn.Put(b.dbi, k, v, flags)
+}
+
+// Delete removes key and its values.
+func (b *Bucket[K, V]) Delete(txn *Txn, key K) error {
+	k, err := b.keys.Encode(key)
+	if err != nil {
+		return err
+	}
+	return txn.Del(b.dbi, k, nil)
+}
+
+// Iterate calls fn for every entry in key order until fn returns false.
+func (b *Bucket[K, V]) Iterate(txn *Txn, fn func(key K, val V) bool) error {
+	return b.iterate(txn, nil, fn)
+}
+
+// Range calls fn for every entry with a key in [from, to) in key order until
+// fn returns false.
+func (b *Bucket[K, V]) Range(txn *Txn, from, to K, fn func(key K, val V) bool) error {
+	lower, err := b.keys.Encode(from)
+	if err != nil {
+		return err
+	}
+	upper, err := b.keys.Encode(to)
+	if err != nil {
+		return err
+	}
+	return b.iterate(txn, &IterOptions{Lower: lower, Upper: upper}, fn)
+}
+
+func (b *Bucket[K, V]) iterate(txn *Txn, opts *IterOptions, fn func(key K, val V) bool) error {
+	it, err := txn.Iterator(b.dbi, opts)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.Next() {
+		k, err := b.keys.Decode(it.Key())
+		if err != nil {
+			return err
+		}
+		v, err := b.vals.Decode(it.Value())
+		if err != nil {
+			return err
+		}
+		if !fn(k, v) {
+			return nil
+		}
+	}
+	return it.Err()
+}
@@ -0,0 +1,140 @@
+//go:build go1.18
+
+package mdb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type testUser struct {
+	Name  string
+	Email string
+}
+
+func TestBucket(t *testing.T) {
+	env := setupMaxDBs(t, 4)
+	defer clean(env, t)
+
+	var users *Bucket[uint64, testUser]
+	var temps *Bucket[int64, float64]
+	var times *Bucket[string, time.Time]
+	err := env.Update(func(txn *Txn) (err error) {
+		users, err = OpenBucket[uint64, testUser](txn, "users", CREATE, Uint64Codec{}, JSONCodec[testUser]{})
+		if err != nil {
+			return err
+		}
+		temps, err = OpenBucket[int64, float64](txn, "temps", CREATE, Int64Codec{}, GobCodec[float64]{})
+		if err != nil {
+			return err
+		}
+		times, err = OpenBucket[string, time.Time](txn, "times", CREATE, StringCodec{}, BinaryCodec[time.Time, *time.Time]{})
+		if err != nil {
+			return err
+		}
+		for _, id := range []uint64{300, 2, 1 << 40, 17} {
+			err = users.Put(txn, id, testUser{Name: "user", Email: "u@example.com"}, 0)
+			if err != nil {
+				return err
+			}
+		}
+		for _, k := range []int64{5, -1, -300, 0, 1 << 50} {
+			err = temps.Put(txn, k, float64(k)/2, 0)
+			if err != nil {
+				return err
+			}
+		}
+		err = times.Put(txn, "epoch", time.Unix(0, 0).UTC(), 0)
+		if err != nil {
+			return err
+		}
+		return users.Delete(txn, 17)
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+
+	err = env.View(func(txn *Txn) error {
+		var ids []uint64
+		err := users.Iterate(txn, func(id uint64, u testUser) bool {
+			if u.Email != "u@example.com" {
+				t.Errorf("Unexpected user %d: %+v", id, u)
+			}
+			ids = append(ids, id)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if len(ids) != 3 || ids[0] != 2 || ids[1] != 300 || ids[2] != 1<<40 {
+			t.Errorf("Unexpected user order: %v", ids)
+		}
+		_, err = users.Get(txn, 17)
+		if err != NotFound {
+			t.Errorf("Deleted user found: %v", err)
+		}
+
+		var keys []int64
+		err = temps.Range(txn, -300, 5, func(k int64, v float64) bool {
+			if v != float64(k)/2 {
+				t.Errorf("Unexpected value for %d: %v", k, v)
+			}
+			keys = append(keys, k)
+			return len(keys) < 3
+		})
+		if err != nil {
+			return err
+		}
+		if len(keys) != 3 || keys[0] != -300 || keys[1] != -1 || keys[2] != 0 {
+			t.Errorf("Unexpected key order: %v", keys)
+		}
+
+		tm, err := times.Get(txn, "epoch")
+		if err != nil {
+			return err
+		}
+		if !tm.Equal(time.Unix(0, 0)) {
+			t.Errorf("Unexpected time: %v", tm)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestOpenBucketFlags(t *testing.T) {
+	env := setupMaxDBs(t, 1)
+	defer clean(env, t)
+
+	err := env.Update(func(txn *Txn) error {
+		_, err := OpenBucket[string, []byte](txn, "b", CREATE|REVERSEKEY, StringCodec{}, BytesCodec{})
+		if err == nil {
+			t.Errorf("Bucket with REVERSEKEY opened")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+}
+
+func TestKeyCodecOrder(t *testing.T) {
+	ints := []int64{-1 << 63, -5, -1, 0, 1, 1<<63 - 1}
+	for i := 1; i < len(ints); i++ {
+		a, _ := Int64Codec{}.Encode(ints[i-1])
+		b, _ := Int64Codec{}.Encode(ints[i])
+		if bytes.Compare(a, b) >= 0 {
+			t.Errorf("Encoding of %d does not sort before %d", ints[i-1], ints[i])
+		}
+		v, err := Int64Codec{}.Decode(b)
+		if err != nil || v != ints[i] {
+			t.Errorf("Decoded %d as %d: %v", ints[i], v, err)
+		}
+	}
+	_, err := Uint64Codec{}.Decode([]byte("short"))
+	if err == nil {
+		t.Errorf("Decoded a short uint64")
+	}
+}
@@ -0,0 +1,552 @@
+package mdb
+
+/*
+#cgo CFLAGS: -pthread -W -Wall -Wno-unused-parameter -Wbad-function-cast -O2 -g
+#include "lmdb.h"
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// changelogDBName is the name of the database holding the recorded change
+// sets keyed by transaction ID. Databases whose names start with
+// internalDBPrefix are not recorded.
+const (
+	changelogDBName  = "__gomdb_changelog"
+	internalDBPrefix = "__gomdb_"
+)
+
+// ChangeOp is the kind of a recorded write.
+type ChangeOp uint8
+
+const (
+	ChangePut   ChangeOp = iota + 1 // Put of Key and Val
+	ChangeDel                       // Del of Key, or of the duplicate Val if not nil
+	ChangeEmpty                     // Drop of all entries of the database
+	ChangeDrop                      // Drop of the database
+)
+
+// Change is a write recorded by the changelog.
+type Change struct {
+	Op    ChangeOp
+	DB    string // name of the database, empty for the main database
+	Flags uint   // flags of the database
+	Key   []byte
+	Val   []byte
+}
+
+// ChangeSet holds the writes of a committed transaction in the order they
+// were made.
+type ChangeSet struct {
+	TxnID   uint64
+	Changes []Change
+}
+
+// changelog holds the subscribers of an environment with an enabled
+// changelog.
+type changelog struct {
+	mu   sync.Mutex
+	subs map[chan *ChangeSet]bool
+}
+
+// txnChanges collects the writes of a write transaction.
+type txnChanges struct {
+	log     *changelog
+	parent  *txnChanges
+	dbs     map[DBI]*Change // name and flags of the written databases
+	changes []Change
+}
+
+// EnableChangelog turns on recording of the writes of the transactions
+// begun afterwards. On commit the writes of a transaction are stored as a
+// ChangeSet in a changelog database, which counts against SetMaxDBs, and
+// sent to subscribers. Writes to the databases used by this package, such as
+// sequences, are not recorded.
+//
+// Writes are recorded by Txn.Put, Del, PutMany and Drop and by Cursor.Put
+// and Del. Values written with the RESERVE flag are recorded before they are
+// filled in and should not be used.
+func (env *Env) EnableChangelog() {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.changelog == nil {
+		env.changelog = &changelog{subs: map[chan *ChangeSet]bool{}}
+	}
+}
+
+func (env *Env) getChangelog() *changelog {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	return env.changelog
+}
+
+// SubscribeChanges returns a channel receiving the change sets of the
+// transactions committed afterwards by this process. Delivery is best
+// effort: change sets are dropped while the channel buffer is full, and
+// subscribers should read missed change sets with Txn.Changes. The returned
+// function unsubscribes and closes the channel. The channel is also closed
+// when the environment is closed.
+func (env *Env) SubscribeChanges(buffer int) (<-chan *ChangeSet, func(), error) {
+	log := env.getChangelog()
+	if log == nil {
+		return nil, nil, errors.New("Changelog not enabled")
+	}
+	ch := make(chan *ChangeSet, buffer)
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.subs == nil {
+		return nil, nil, errors.New("Environment closed")
+	}
+	log.subs[ch] = true
+	cancel := func() {
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		if log.subs[ch] {
+			delete(log.subs, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel, nil
+}
+
+func (log *changelog) notify(cs *ChangeSet) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	for ch := range log.subs {
+		select {
+		case ch <- cs:
+		default:
+		}
+	}
+}
+
+// close closes the channels of all subscribers.
+func (log *changelog) close() {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	for ch := range log.subs {
+		close(ch)
+	}
+	log.subs = nil
+}
+
+// db returns the name and flags of dbi.
+func (c *txnChanges) db(txn *Txn, dbi DBI) *Change {
+	db, ok := c.dbs[dbi]
+	if !ok {
+		name, _ := txn.dbiName(dbi)
+		flags, _ := txn.Flags(dbi)
+		db = &Change{DB: name, Flags: flags}
+		c.dbs[dbi] = db
+	}
+	return db
+}
+
+// add records a write to dbi unless dbi is an internal database.
+func (c *txnChanges) add(txn *Txn, dbi DBI, op ChangeOp, key, val []byte) {
+	db := c.db(txn, dbi)
+	if strings.HasPrefix(db.DB, internalDBPrefix) {
+		return
+	}
+	change := Change{Op: op, DB: db.DB, Flags: db.Flags}
+	if key != nil {
+		change.Key = append([]byte{}, key...)
+	}
+	if val != nil {
+		change.Val = append([]byte{}, val...)
+	}
+	c.changes = append(c.changes, change)
+}
+
+// logChanges stores the writes of a top-level transaction in the changelog
+// database and returns the stored change set. The writes of a nested
+// transaction are handed to its parent by keepChanges once it commits.
+func (txn *Txn) logChanges() (*ChangeSet, error) {
+	c := txn.changes
+	if c == nil || len(c.changes) == 0 || c.parent != nil {
+		return nil, nil
+	}
+	// The write lock is held, so the transaction follows the last committed
+	// one.
+	var info C.MDB_envinfo
+	ret := C.mdb_env_info(C.mdb_txn_env(txn._txn), &info)
+	if ret != SUCCESS {
+		return nil, errno(ret)
+	}
+	cs := &ChangeSet{TxnID: uint64(info.me_last_txnid) + 1, Changes: c.changes}
+	dbi, err := txn.internalDBI(changelogDBName, true)
+	if err != nil {
+		return nil, err
+	}
+	err = txn.Put(dbi, changelogKey(cs.TxnID), encodeChanges(cs.Changes), APPEND)
+	if err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// keepChanges hands the writes of a committed nested transaction to its
+// parent.
+func (c *txnChanges) keepChanges() {
+	if c != nil && c.parent != nil {
+		c.parent.changes = append(c.parent.changes, c.changes...)
+	}
+}
+
+func changelogKey(txnid uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, txnid)
+	return key
+}
+
+// Changes calls fn with the change sets of the transactions after txnid in
+// commit order until fn returns an error.
+func (txn *Txn) Changes(after uint64, fn func(cs *ChangeSet) error) error {
+	dbi, err := txn.internalDBI(changelogDBName, false)
+	if err == NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cursor, err := txn.CursorOpen(dbi)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	var changes []Change
+	k, v, err := cursor.GetVal(changelogKey(after+1), nil, SET_RANGE)
+	for err == nil {
+		key := k.BytesNoCopy()
+		if len(key) != 8 {
+			return errors.New("Invalid changelog key")
+		}
+		changes, err = decodeChanges(v.BytesNoCopy())
+		if err != nil {
+			return err
+		}
+		err = fn(&ChangeSet{TxnID: binary.BigEndian.Uint64(key), Changes: changes})
+		if err != nil {
+			return err
+		}
+		k, v, err = cursor.GetVal(nil, nil, NEXT)
+	}
+	if err != NotFound {
+		return err
+	}
+	return nil
+}
+
+// changesTrimmedKey is the meta database key of the ID of the last deleted
+// change set.
+var changesTrimmedKey = []byte("changelog:trimmed")
+
+// TrimChanges deletes the change sets of the transactions up to and
+// including txnid and returns the number of deleted change sets. The ID of
+// the last deleted change set is returned by ChangesTrimmed afterwards.
+func (txn *Txn) TrimChanges(txnid uint64) (int, error) {
+	dbi, err := txn.internalDBI(changelogDBName, false)
+	if err == NotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	cursor, err := txn.CursorOpen(dbi)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+	n := 0
+	var last uint64
+	k, _, err := cursor.GetVal(nil, nil, FIRST)
+	for err == nil && binary.BigEndian.Uint64(k.BytesNoCopy()) <= txnid {
+		last = binary.BigEndian.Uint64(k.BytesNoCopy())
+		err = cursor.Del(0)
+		if err != nil {
+			return n, err
+		}
+		n++
+		k, _, err = cursor.GetVal(nil, nil, FIRST)
+	}
+	if err != nil && err != NotFound {
+		return n, err
+	}
+	if n > 0 {
+		meta, err := txn.openMeta(true)
+		if err != nil {
+			return n, err
+		}
+		err = txn.Put(meta, changesTrimmedKey, changelogKey(last), 0)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ChangesTrimmed returns the ID of the last change set deleted by
+// TrimChanges, or 0 if none was deleted. Readers of the changelog that are
+// behind this ID have missed change sets.
+func (txn *Txn) ChangesTrimmed() (uint64, error) {
+	val, err := getMeta(txn, changesTrimmedKey)
+	if err != nil || val == nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, errors.New("Invalid changelog trim position")
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// LastChange returns the ID of the last recorded change set, or the value of
+// ChangesTrimmed if the changelog is empty.
+func (txn *Txn) LastChange() (uint64, error) {
+	trimmed, err := txn.ChangesTrimmed()
+	if err != nil {
+		return 0, err
+	}
+	dbi, err := txn.internalDBI(changelogDBName, false)
+	if err == NotFound {
+		return trimmed, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	cursor, err := txn.CursorOpen(dbi)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+	k, _, err := cursor.GetVal(nil, nil, LAST)
+	if err == NotFound {
+		return trimmed, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(k.BytesNoCopy()), nil
+}
+
+// ChangeConsumer reads the changelog and stores how far it got under its
+// name in the metadata database, so that it resumes there after a restart.
+// A change set may be passed to the consumer again if the process stops
+// before its position is stored.
+type ChangeConsumer struct {
+	env  *Env
+	name string
+}
+
+// consumeBatchSize is the maximum number of change sets a ChangeConsumer passes on
+// between storing its position.
+const consumeBatchSize = 100
+
+// NewChangeConsumer returns the consumer called name.
+func (env *Env) NewChangeConsumer(name string) *ChangeConsumer {
+	return &ChangeConsumer{env: env, name: name}
+}
+
+func (c *ChangeConsumer) key() []byte {
+	return []byte("cdc:" + c.name)
+}
+
+// Position returns the ID of the last transaction whose change set was
+// consumed.
+func (c *ChangeConsumer) Position() (uint64, error) {
+	var pos uint64
+	err := c.env.View(func(txn *Txn) error {
+		val, err := getMeta(txn, c.key())
+		if err != nil || val == nil {
+			return err
+		}
+		if len(val) != 8 {
+			return errors.New("Invalid consumer position")
+		}
+		pos = binary.BigEndian.Uint64(val)
+		return nil
+	})
+	return pos, err
+}
+
+// SetPosition sets the ID of the last consumed transaction.
+func (c *ChangeConsumer) SetPosition(txnid uint64) error {
+	return c.env.Update(func(txn *Txn) error {
+		meta, err := txn.openMeta(true)
+		if err != nil {
+			return err
+		}
+		return txn.Put(meta, c.key(), changelogKey(txnid), 0)
+	})
+}
+
+// Consume calls fn with the change sets after the position of the consumer
+// in commit order and then waits for new ones. It returns when fn fails or
+// ctx is done.
+func (c *ChangeConsumer) Consume(ctx context.Context, fn func(cs *ChangeSet) error) error {
+	// Subscribe first so that no commit after the last read is missed.
+	notify, cancel, err := c.env.SubscribeChanges(1)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	pos, err := c.Position()
+	if err != nil {
+		return err
+	}
+	for {
+		var batch []*ChangeSet
+		err = c.env.View(func(txn *Txn) error {
+			return txn.Changes(pos, func(cs *ChangeSet) error {
+				batch = append(batch, cs)
+				if len(batch) == consumeBatchSize {
+					return errBatchFull
+				}
+				return nil
+			})
+		})
+		if err != nil && err != errBatchFull {
+			return err
+		}
+		start := pos
+		var fnErr error
+		for _, cs := range batch {
+			fnErr = fn(cs)
+			if fnErr != nil {
+				break
+			}
+			pos = cs.TxnID
+		}
+		if pos != start {
+			err = c.SetPosition(pos)
+			if err != nil {
+				return err
+			}
+		}
+		if fnErr != nil {
+			return fnErr
+		}
+		if len(batch) == consumeBatchSize {
+			continue
+		}
+		select {
+		case _, ok := <-notify:
+			if !ok {
+				return errors.New("Environment closed")
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+var errBatchFull = errors.New("batch full")
+
+// The change sets are stored as a count followed by the changes, each an
+// operation, the flags and the database name, key and value. Byte strings
+// are stored as their length plus one, or 0 for nil, and their bytes.
+func encodeChanges(changes []Change) []byte {
+	buf := make([]byte, 0, 64*len(changes))
+	buf = appendUvarint(buf, uint64(len(changes)))
+	for _, c := range changes {
+		buf = append(buf, byte(c.Op))
+		buf = appendUvarint(buf, uint64(c.Flags))
+		buf = appendBytes(buf, []byte(c.DB))
+		buf = appendBytes(buf, c.Key)
+		buf = appendBytes(buf, c.Val)
+	}
+	return buf
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func appendBytes(buf, p []byte) []byte {
+	if p == nil {
+		return append(buf, 0)
+	}
+	buf = appendUvarint(buf, uint64(len(p))+1)
+	return append(buf, p...)
+}
+
+var errInvalidChanges = errors.New("Invalid changelog entry")
+
+// MarshalBinary encodes the change set.
+func (cs *ChangeSet) MarshalBinary() ([]byte, error) {
+	return append(appendUvarint(nil, cs.TxnID), encodeChanges(cs.Changes)...), nil
+}
+
+// UnmarshalBinary decodes a change set encoded by MarshalBinary.
+func (cs *ChangeSet) UnmarshalBinary(p []byte) error {
+	txnid, n := binary.Uvarint(p)
+	if n <= 0 {
+		return errInvalidChanges
+	}
+	changes, err := decodeChanges(p[n:])
+	if err != nil {
+		return err
+	}
+	cs.TxnID, cs.Changes = txnid, changes
+	return nil
+}
+
+func decodeChanges(p []byte) ([]Change, error) {
+	d := changeDecoder{p: p}
+	n := d.uvarint()
+	if d.err != nil || n > uint64(len(p)) {
+		return nil, errInvalidChanges
+	}
+	changes := make([]Change, n)
+	for i := range changes {
+		c := &changes[i]
+		if len(d.p) == 0 {
+			return nil, errInvalidChanges
+		}
+		c.Op = ChangeOp(d.p[0])
+		d.p = d.p[1:]
+		c.Flags = uint(d.uvarint())
+		c.DB = string(d.bytes())
+		c.Key = d.bytes()
+		c.Val = d.bytes()
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+	return changes, nil
+}
+
+type changeDecoder struct {
+	p   []byte
+	err error
+}
+
+func (d *changeDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.p)
+	if n <= 0 {
+		d.err = errInvalidChanges
+		return 0
+	}
+	d.p = d.p[n:]
+	return v
+}
+
+func (d *changeDecoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	if n-1 > uint64(len(d.p)) {
+		d.err = errInvalidChanges
+		return nil
+	}
+	p := append([]byte{}, d.p[:n-1]...)
+	d.p = d.p[n-1:]
+	return p
+}
@@ -0,0 +1,313 @@
+package mdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func formatChanges(cs *ChangeSet) string {
+	var s []string
+	for _, c := range cs.Changes {
+		s = append(s, fmt.Sprintf("%d:%s:%s=%s", c.Op, c.DB, c.Key, c.Val))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestChangelog(t *testing.T) {
+	env := setupMaxDBs(t, 4)
+	defer clean(env, t)
+
+	env.EnableChangelog()
+	sub, cancel, err := env.SubscribeChanges(10)
+	if err != nil {
+		t.Fatalf("Cannot subscribe: %s", err)
+	}
+	defer cancel()
+
+	name := "dups"
+	var main, dups DBI
+	err = env.Update(func(txn *Txn) (err error) {
+		main, err = txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		dups, err = txn.DBIOpen(&name, CREATE|DUPSORT)
+		if err != nil {
+			return err
+		}
+		err = txn.Put(main, []byte("a"), []byte("1"), 0)
+		if err != nil {
+			return err
+		}
+		err = txn.PutMany(dups, []KV{{[]byte("k"), []byte("x")}, {[]byte("k"), []byte("y")}}, 0)
+		if err != nil {
+			return err
+		}
+		_, err = txn.NextSequence(main)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		child, err := env.BeginTxn(txn, 0)
+		if err != nil {
+			return err
+		}
+		err = child.Put(main, []byte("b"), []byte("2"), 0)
+		if err != nil {
+			return err
+		}
+		err = child.Commit()
+		if err != nil {
+			return err
+		}
+		child, err = env.BeginTxn(txn, 0)
+		if err != nil {
+			return err
+		}
+		child.Put(main, []byte("c"), []byte("3"), 0)
+		child.Abort()
+
+		cursor, err := txn.CursorOpen(dups)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close()
+		_, _, err = cursor.Get([]byte("k"), []byte("y"), GET_BOTH)
+		if err != nil {
+			return err
+		}
+		err = cursor.Del(0)
+		if err != nil {
+			return err
+		}
+		return txn.Del(main, []byte("a"), nil)
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+	errFail := errors.New("fail")
+	err = env.Update(func(txn *Txn) error {
+		txn.Put(main, []byte("d"), []byte("4"), 0)
+		return errFail
+	})
+	if err != errFail {
+		t.Fatalf("Update returned %v", err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		if txn.Drop(dups, 2) == nil {
+			t.Errorf("Drop with an invalid argument succeeded")
+		}
+		return txn.Drop(dups, 0)
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+
+	expected := []string{
+		"1::a=1 1:dups:k=x 1:dups:k=y",
+		"1::b=2 2:dups:k=y 2::a=",
+		"3:dups:=",
+	}
+	var ids []uint64
+	for i, exp := range expected {
+		select {
+		case cs := <-sub:
+			if s := formatChanges(cs); s != exp {
+				t.Errorf("Change set %d: %q, expected %q", i, s, exp)
+			}
+			ids = append(ids, cs.TxnID)
+		case <-time.After(time.Second):
+			t.Fatalf("Change set %d not received", i)
+		}
+	}
+	info, err := env.Info()
+	if err != nil {
+		t.Fatalf("Cannot get info: %s", err)
+	}
+	if ids[2] != info.LastTxnID {
+		t.Errorf("Last change set has ID %d, last transaction is %d", ids[2], info.LastTxnID)
+	}
+
+	var read []string
+	err = env.View(func(txn *Txn) error {
+		return txn.Changes(ids[0], func(cs *ChangeSet) error {
+			read = append(read, formatChanges(cs))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("Cannot read changes: %s", err)
+	}
+	if strings.Join(read, "|") != strings.Join(expected[1:], "|") {
+		t.Errorf("Unexpected changes: %q", read)
+	}
+
+	err = env.Update(func(txn *Txn) error {
+		n, err := txn.TrimChanges(ids[1])
+		if err != nil {
+			return err
+		}
+		if n != 2 {
+			t.Errorf("Trimmed %d change sets", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+	read = nil
+	err = env.View(func(txn *Txn) error {
+		return txn.Changes(0, func(cs *ChangeSet) error {
+			read = append(read, formatChanges(cs))
+			return nil
+		})
+	})
+	if err != nil || len(read) != 1 {
+		t.Errorf("Unexpected changes after trimming: %q, %v", read, err)
+	}
+	err = env.View(func(txn *Txn) error {
+		trimmed, err := txn.ChangesTrimmed()
+		if err != nil {
+			return err
+		}
+		last, err := txn.LastChange()
+		if err != nil {
+			return err
+		}
+		if trimmed != ids[1] || last != ids[2] {
+			t.Errorf("Trimmed up to %d, last change %d", trimmed, last)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+
+	var cs ChangeSet
+	p, _ := (&ChangeSet{TxnID: 7, Changes: []Change{{Op: ChangeDel, DB: "x", Flags: DUPSORT, Key: []byte("k"), Val: []byte{}}}}).MarshalBinary()
+	err = cs.UnmarshalBinary(p)
+	if err != nil || cs.TxnID != 7 || formatChanges(&cs) != "2:x:k=" || cs.Changes[0].Val == nil || cs.Changes[0].Flags != DUPSORT {
+		t.Errorf("Unexpected decoded change set: %+v, %v", cs, err)
+	}
+	if cs.UnmarshalBinary(p[:len(p)-1]) == nil {
+		t.Errorf("Truncated change set decoded")
+	}
+}
+
+func TestChangelogFailedChildCommit(t *testing.T) {
+	env := setupMaxDBs(t, 2)
+	defer clean(env, t)
+
+	err := env.SetMapSize(1 << 16)
+	if err != nil {
+		t.Fatalf("Cannot set mapsize: %s", err)
+	}
+	env.EnableChangelog()
+	err = env.Update(func(txn *Txn) error {
+		dbi, err := txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		child, err := env.BeginTxn(txn, 0)
+		if err != nil {
+			return err
+		}
+		// Fill the map, which fails the child.
+		for i := 0; err == nil; i++ {
+			err = child.Put(dbi, []byte(fmt.Sprintf("%d", i)), make([]byte, 2048), 0)
+		}
+		if err != MapFull {
+			child.Abort()
+			return err
+		}
+		if child.Commit() == nil {
+			t.Errorf("Failed child committed")
+		}
+		if n := len(txn.changes.changes); n != 0 {
+			t.Errorf("%d changes of the failed child kept", n)
+		}
+		return nil
+	})
+	if err == nil {
+		t.Errorf("Parent of a failed child committed")
+	}
+}
+
+func TestChangeConsumer(t *testing.T) {
+	env := setupMaxDBs(t, 2)
+	defer clean(env, t)
+
+	env.EnableChangelog()
+	var dbi DBI
+	put := func(key string) {
+		err := env.Update(func(txn *Txn) (err error) {
+			dbi, err = txn.DBIOpen(nil, 0)
+			if err != nil {
+				return err
+			}
+			return txn.Put(dbi, []byte(key), nil, 0)
+		})
+		if err != nil {
+			t.Fatalf("Update: %s", err)
+		}
+	}
+	put("a")
+	put("b")
+
+	c := env.NewChangeConsumer("indexer")
+	errStop := errors.New("stop")
+	var keys []string
+	err := c.Consume(context.Background(), func(cs *ChangeSet) error {
+		if string(cs.Changes[0].Key) == "b" {
+			return errStop
+		}
+		keys = append(keys, string(cs.Changes[0].Key))
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("Consume returned %v", err)
+	}
+
+	// The consumer resumes after a, waits for c and is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Consume(ctx, func(cs *ChangeSet) error {
+			keys = append(keys, string(cs.Changes[0].Key))
+			if len(keys) == 3 {
+				cancel()
+			}
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	put("c")
+	select {
+	case err = <-done:
+		if err != context.Canceled {
+			t.Errorf("Consume returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Consumer did not receive c")
+	}
+	if strings.Join(keys, " ") != "a b c" {
+		t.Errorf("Unexpected consumed keys: %q", keys)
+	}
+	pos, err := c.Position()
+	if err != nil {
+		t.Fatalf("Cannot get position: %s", err)
+	}
+	info, err := env.Info()
+	if err != nil {
+		t.Fatalf("Cannot get info: %s", err)
+	}
+	if pos >= info.LastTxnID || pos == 0 {
+		t.Errorf("Unexpected position %d, last transaction %d", pos, info.LastTxnID)
+	}
+}
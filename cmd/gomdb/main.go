@@ -0,0 +1,719 @@
+// Command gomdb inspects and maintains LMDB environments.
+//
+// Usage:
+//
+//	gomdb <command> [flags] <path> [arguments]
+//
+// The commands are:
+//
+//	stat     print environment and database statistics
+//	list     list the named databases
+//	get      print the value of a key
+//	put      store a value
+//	del      delete a key or a duplicate
+//	scan     print the entries of a database
+//	dump     write databases in the mdb_dump format
+//	load     read databases in the mdb_dump format
+//	copy     copy the environment
+//	backup   write a full or incremental backup
+//	restore  restore backups into a data file
+//	readers  list or check the reader lock table
+//	verify   check the consistency of the environment
+//
+// Run "gomdb <command> -h" for the flags of a command.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	mdb "github.com/szferi/gomdb"
+)
+
+type command struct {
+	args  string
+	usage string
+	run   func(c *cli, fs *flag.FlagSet, args []string) error
+}
+
+var commands = map[string]*command{
+	"stat":    {"<path>", "print environment and database statistics", (*cli).stat},
+	"list":    {"<path>", "list the named databases", (*cli).list},
+	"get":     {"<path> <key>", "print the value of a key", (*cli).get},
+	"put":     {"<path> <key> <value>", "store a value", (*cli).put},
+	"del":     {"<path> <key> [value]", "delete a key or a duplicate", (*cli).del},
+	"scan":    {"<path>", "print the entries of a database", (*cli).scan},
+	"dump":    {"<path>", "write databases in the mdb_dump format", (*cli).dump},
+	"load":    {"<path>", "read databases in the mdb_dump format", (*cli).load},
+	"copy":    {"<path> <dest>", "copy the environment", (*cli).copy},
+	"backup":  {"<path>", "write a full or incremental backup", (*cli).backup},
+	"restore": {"<path> <backup>...", "restore backups into a data file", (*cli).restore},
+	"readers": {"<path>", "list or check the reader lock table", (*cli).readers},
+	"verify":  {"<path>", "check the consistency of the environment", (*cli).verify},
+}
+
+// cli holds the flags shared by all commands.
+type cli struct {
+	stdin    io.Reader
+	stdout   io.Writer
+	noSubdir bool
+	maxDBs   uint
+	db       string
+	hex      bool
+}
+
+func main() {
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout}
+	err := c.run(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gomdb: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: gomdb <command> [flags] <path> [arguments]\n\ncommands:\n")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-8s %s\n", name, commands[name].usage)
+	}
+}
+
+func (c *cli) run(args []string) error {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return flag.ErrHelp
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage(os.Stderr)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: gomdb %s [flags] %s\n", args[0], cmd.args)
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&c.noSubdir, "n", false, "the path is the data file, not a directory")
+	fs.UintVar(&c.maxDBs, "maxdbs", 128, "maximum number of named databases")
+	return cmd.run(c, fs, args[1:])
+}
+
+// dbFlags adds the flags selecting a database and the encoding of keys and
+// values.
+func (c *cli) dbFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.db, "s", "", "name of the database, the main database if empty")
+	fs.BoolVar(&c.hex, "x", false, "keys and values are hexadecimal")
+}
+
+// parse parses the flags and checks that n positional arguments remain, or
+// up to n+optional.
+func parse(fs *flag.FlagSet, args []string, n, optional int) ([]string, error) {
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() < n || fs.NArg() > n+optional {
+		fs.Usage()
+		return nil, flag.ErrHelp
+	}
+	return fs.Args(), nil
+}
+
+// open opens the environment at path.
+func (c *cli) open(path string, flags uint) (*mdb.Env, error) {
+	env, err := mdb.NewEnv()
+	if err != nil {
+		return nil, err
+	}
+	err = env.SetMaxDBs(mdb.DBI(c.maxDBs))
+	if err == nil {
+		if c.noSubdir {
+			flags |= mdb.NOSUBDIR
+		}
+		err = env.Open(path, flags, 0644)
+	}
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	return env, nil
+}
+
+// openDB opens the database selected with -s.
+func (c *cli) openDB(txn *mdb.Txn, flags uint) (mdb.DBI, error) {
+	if c.db == "" {
+		return txn.DBIOpen(nil, flags)
+	}
+	return txn.DBIOpen(&c.db, flags)
+}
+
+func (c *cli) decode(s string) ([]byte, error) {
+	if c.hex {
+		return hex.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+func (c *cli) encode(p []byte) string {
+	if c.hex {
+		return hex.EncodeToString(p)
+	}
+	return string(p)
+}
+
+func (c *cli) stat(fs *flag.FlagSet, args []string) error {
+	all := fs.Bool("a", false, "print the statistics of all named databases")
+	free := fs.Bool("f", false, "print free list statistics")
+	fs.StringVar(&c.db, "s", "", "print the statistics of the named database")
+	args, err := parse(fs, args, 1, 0)
+	if err != nil {
+		return err
+	}
+	env, err := c.open(args[0], mdb.RDONLY)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	info, err := env.Info()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Environment Info\n")
+	fmt.Fprintf(c.stdout, "  Map size: %d\n", info.MapSize)
+	fmt.Fprintf(c.stdout, "  Number of pages used: %d\n", info.LastPNO+1)
+	fmt.Fprintf(c.stdout, "  Last transaction ID: %d\n", info.LastTxnID)
+	fmt.Fprintf(c.stdout, "  Max readers: %d\n", info.MaxReaders)
+	fmt.Fprintf(c.stdout, "  Number of readers used: %d\n", info.NumReaders)
+	return env.View(func(txn *mdb.Txn) error {
+		if *free {
+			stat, err := txn.Stat(mdb.FREE_DBI)
+			if err != nil {
+				return err
+			}
+			pages, err := txn.FreePages()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(c.stdout, "Freelist Status\n")
+			c.printStat(stat)
+			fmt.Fprintf(c.stdout, "  Free pages: %d\n", pages)
+		}
+		dbi, err := c.openDB(txn, 0)
+		if err != nil {
+			return err
+		}
+		stat, err := txn.Stat(dbi)
+		if err != nil {
+			return err
+		}
+		if c.db == "" {
+			fmt.Fprintf(c.stdout, "Status of Main DB\n")
+		} else {
+			fmt.Fprintf(c.stdout, "Status of %s\n", c.db)
+		}
+		c.printStat(stat)
+		if !*all {
+			return nil
+		}
+		dbs, err := txn.ListDBs()
+		if err != nil {
+			return err
+		}
+		for _, db := range dbs {
+			fmt.Fprintf(c.stdout, "Status of %s\n", db.Name)
+			c.printStat(&db.Stat)
+		}
+		return nil
+	})
+}
+
+func (c *cli) printStat(stat *mdb.Stat) {
+	fmt.Fprintf(c.stdout, "  Tree depth: %d\n", stat.Depth)
+	fmt.Fprintf(c.stdout, "  Branch pages: %d\n", stat.BranchPages)
+	fmt.Fprintf(c.stdout, "  Leaf pages: %d\n", stat.LeafPages)
+	fmt.Fprintf(c.stdout, "  Overflow pages: %d\n", stat.OverflowPages)
+	fmt.Fprintf(c.stdout, "  Entries: %d\n", stat.Entries)
+}
+
+func (c *cli) list(fs *flag.FlagSet, args []string) error {
+	long := fs.Bool("l", false, "also print the flags and number of entries")
+	args, err := parse(fs, args, 1, 0)
+	if err != nil {
+		return err
+	}
+	env, err := c.open(args[0], mdb.RDONLY)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	return env.View(func(txn *mdb.Txn) error {
+		dbs, err := txn.ListDBs()
+		if err != nil {
+			return err
+		}
+		for _, db := range dbs {
+			if *long {
+				fmt.Fprintf(c.stdout, "%s\t%#x\t%d\n", db.Name, db.Flags, db.Stat.Entries)
+			} else {
+				fmt.Fprintln(c.stdout, db.Name)
+			}
+		}
+		return nil
+	})
+}
+
+func (c *cli) get(fs *flag.FlagSet, args []string) error {
+	c.dbFlags(fs)
+	args, err := parse(fs, args, 2, 0)
+	if err != nil {
+		return err
+	}
+	key, err := c.decode(args[1])
+	if err != nil {
+		return err
+	}
+	env, err := c.open(args[0], mdb.RDONLY)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	return env.View(func(txn *mdb.Txn) error {
+		dbi, err := c.openDB(txn, 0)
+		if err != nil {
+			return err
+		}
+		val, err := txn.Get(dbi, key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.stdout, c.encode(val))
+		return nil
+	})
+}
+
+func (c *cli) put(fs *flag.FlagSet, args []string) error {
+	c.dbFlags(fs)
+	create := fs.Bool("create", false, "create the database if it does not exist")
+	dupsort := fs.Bool("dupsort", false, "create the database with sorted duplicates")
+	noOverwrite := fs.Bool("nooverwrite", false, "fail if the key exists")
+	args, err := parse(fs, args, 3, 0)
+	if err != nil {
+		return err
+	}
+	key, err := c.decode(args[1])
+	if err != nil {
+		return err
+	}
+	val, err := c.decode(args[2])
+	if err != nil {
+		return err
+	}
+	var dbFlags, putFlags uint
+	if *create {
+		dbFlags |= mdb.CREATE
+	}
+	if *dupsort {
+		dbFlags |= mdb.DUPSORT
+	}
+	if *noOverwrite {
+		putFlags |= mdb.NOOVERWRITE
+	}
+	env, err := c.open(args[0], 0)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	return env.Update(func(txn *mdb.Txn) error {
+		dbi, err := c.openDB(txn, dbFlags)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, key, val, putFlags)
+	})
+}
+
+func (c *cli) del(fs *flag.FlagSet, args []string) error {
+	c.dbFlags(fs)
+	args, err := parse(fs, args, 2, 1)
+	if err != nil {
+		return err
+	}
+	key, err := c.decode(args[1])
+	if err != nil {
+		return err
+	}
+	var val []byte
+	if len(args) > 2 {
+		val, err = c.decode(args[2])
+		if err != nil {
+			return err
+		}
+	}
+	env, err := c.open(args[0], 0)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	return env.Update(func(txn *mdb.Txn) error {
+		dbi, err := c.openDB(txn, 0)
+		if err != nil {
+			return err
+		}
+		return txn.Del(dbi, key, val)
+	})
+}
+
+func (c *cli) scan(fs *flag.FlagSet, args []string) error {
+	c.dbFlags(fs)
+	prefix := fs.String("prefix", "", "only print keys with this prefix")
+	from := fs.String("from", "", "first key to print")
+	limit := fs.Int("limit", 0, "maximum number of entries to print, 0 for all")
+	reverse := fs.Bool("r", false, "print entries in descending order")
+	keysOnly := fs.Bool("k", false, "only print keys")
+	args, err := parse(fs, args, 1, 0)
+	if err != nil {
+		return err
+	}
+	opts := &mdb.IterOptions{Reverse: *reverse}
+	if *prefix != "" {
+		opts.Prefix, err = c.decode(*prefix)
+		if err != nil {
+			return err
+		}
+	}
+	env, err := c.open(args[0], mdb.RDONLY)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	return env.View(func(txn *mdb.Txn) error {
+		dbi, err := c.openDB(txn, 0)
+		if err != nil {
+			return err
+		}
+		it, err := txn.Iterator(dbi, opts)
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		ok := it.First()
+		if *from != "" {
+			start, err := c.decode(*from)
+			if err != nil {
+				return err
+			}
+			ok = it.Seek(start)
+		}
+		for n := 0; ok && (*limit <= 0 || n < *limit); n++ {
+			if *keysOnly {
+				fmt.Fprintln(c.stdout, c.encode(it.KeyNoCopy()))
+			} else {
+				fmt.Fprintf(c.stdout, "%s\t%s\n", c.encode(it.KeyNoCopy()), c.encode(it.ValueNoCopy()))
+			}
+			ok = it.Next()
+		}
+		return it.Err()
+	})
+}
+
+func (c *cli) dump(fs *flag.FlagSet, args []string) error {
+	all := fs.Bool("a", false, "dump all databases")
+	printable := fs.Bool("p", false, "write printable characters as they are")
+	out := fs.String("f", "", "write to this file instead of the standard output")
+	fs.StringVar(&c.db, "s", "", "name of the database, the main database if empty")
+	args, err := parse(fs, args, 1, 0)
+	if err != nil {
+		return err
+	}
+	env, err := c.open(args[0], mdb.RDONLY)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	w := c.stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	opts := mdb.DumpOptions{Print: *printable}
+	err = env.View(func(txn *mdb.Txn) error {
+		if *all {
+			return mdb.DumpAll(txn, w, opts)
+		}
+		dbi, err := c.openDB(txn, 0)
+		if err != nil {
+			return err
+		}
+		return mdb.DumpWithOptions(txn, dbi, w, opts)
+	})
+	if err != nil {
+		return err
+	}
+	if f, ok := w.(*os.File); ok && *out != "" {
+		return f.Sync()
+	}
+	return nil
+}
+
+func (c *cli) load(fs *flag.FlagSet, args []string) error {
+	in := fs.String("f", "", "read from this file instead of the standard input")
+	args, err := parse(fs, args, 1, 0)
+	if err != nil {
+		return err
+	}
+	r := c.stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if !c.noSubdir {
+		err = os.MkdirAll(args[0], 0755)
+		if err != nil {
+			return err
+		}
+	}
+	env, err := c.open(args[0], 0)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	return mdb.Load(env, r)
+}
+
+func (c *cli) copy(fs *flag.FlagSet, args []string) error {
+	compact := fs.Bool("compact", false, "omit free pages and renumber pages")
+	args, err := parse(fs, args, 2, 0)
+	if err != nil {
+		return err
+	}
+	env, err := c.open(args[0], mdb.RDONLY)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	if args[1] == "-" {
+		return env.CopyTo(c.stdout, *compact)
+	}
+	return env.CopyWithOptions(args[1], mdb.CopyOptions{Compact: *compact})
+}
+
+func (c *cli) backup(fs *flag.FlagSet, args []string) error {
+	out := fs.String("f", "", "write to this file instead of the standard output")
+	manifest := fs.String("m", "", "write the manifest of the backup to this file")
+	incremental := fs.Bool("i", false, "only write the pages changed since the backup of the manifest given with -m")
+	args, err := parse(fs, args, 1, 0)
+	if err != nil {
+		return err
+	}
+	if *manifest == "" {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	var base io.Reader
+	if *incremental {
+		f, err := os.Open(*manifest)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		base = f
+	}
+	env, err := c.open(args[0], mdb.RDONLY)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	w := c.stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	// Replace the manifest only once the backup is written.
+	m, err := os.Create(*manifest + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(m.Name())
+	defer m.Close()
+	_, err = env.BackupIncremental(w, base, m)
+	if err != nil {
+		return err
+	}
+	if f, ok := w.(*os.File); ok && *out != "" {
+		err = f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	err = m.Sync()
+	if err == nil {
+		err = m.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(m.Name(), *manifest)
+}
+
+func (c *cli) restore(fs *flag.FlagSet, args []string) error {
+	args, err := parse(fs, args, 2, 1<<30)
+	if err != nil {
+		return err
+	}
+	path := args[0]
+	if !c.noSubdir {
+		err = os.MkdirAll(path, 0755)
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(path, "data.mdb")
+	}
+	// The backups are applied to a copy of the data file, which replaces
+	// it once the last backup is verified.
+	tmp := path + ".restore"
+	defer os.Remove(tmp)
+	err = copyFile(tmp, path)
+	if err != nil {
+		return err
+	}
+	for _, name := range args[1:] {
+		err = c.restoreBackup(tmp, name)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return os.Rename(tmp, path)
+}
+
+func (c *cli) restoreBackup(path, name string) error {
+	if name == "-" {
+		return mdb.RestoreBackup(path, c.stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return mdb.RestoreBackup(path, f)
+}
+
+// copyFile copies the file src to dst. If src does not exist dst is
+// removed.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		err = os.Remove(dst)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *cli) readers(fs *flag.FlagSet, args []string) error {
+	check := fs.Bool("check", false, "clear stale entries of dead processes")
+	args, err := parse(fs, args, 1, 0)
+	if err != nil {
+		return err
+	}
+	env, err := c.open(args[0], mdb.RDONLY)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	if *check {
+		n, err := env.ReaderCheck()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "%d stale readers cleared.\n", n)
+	}
+	readers, err := env.Readers()
+	if err != nil {
+		return err
+	}
+	if len(readers) == 0 {
+		fmt.Fprintln(c.stdout, "(no active readers)")
+		return nil
+	}
+	fmt.Fprintf(c.stdout, "%10s %16s %10s\n", "pid", "thread", "txnid")
+	for _, r := range readers {
+		if !r.Active {
+			fmt.Fprintf(c.stdout, "%10d %16x %10s\n", r.PID, r.Thread, "-")
+			continue
+		}
+		fmt.Fprintf(c.stdout, "%10d %16x %10d\n", r.PID, r.Thread, r.TxnID)
+	}
+	return nil
+}
+
+func (c *cli) verify(fs *flag.FlagSet, args []string) error {
+	skipOrder := fs.Bool("noorder", false, "do not check the order of keys, for databases with custom comparators")
+	max := fs.Int("max", 0, "stop after this many problems, 0 for no limit")
+	args, err := parse(fs, args, 1, 0)
+	if err != nil {
+		return err
+	}
+	env, err := c.open(args[0], mdb.RDONLY)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	report, err := env.Verify(mdb.VerifyOptions{SkipOrder: *skipOrder, MaxProblems: *max})
+	if err != nil {
+		return err
+	}
+	for _, p := range report.Problems {
+		fmt.Fprintln(c.stdout, p)
+	}
+	fmt.Fprintf(c.stdout, "%d databases, %d entries, %d pages: %d used, %d free\n",
+		report.DBs, report.Entries, report.Pages, report.UsedPages, report.FreePages)
+	if report.Truncated {
+		fmt.Fprintln(c.stdout, "Stopped after too many problems.")
+	}
+	if !report.OK() {
+		return fmt.Errorf("%d problems found", len(report.Problems))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, stdin string, args ...string) string {
+	var out bytes.Buffer
+	c := &cli{stdin: strings.NewReader(stdin), stdout: &out}
+	err := c.run(args)
+	if err != nil {
+		t.Fatalf("gomdb %s: %s", strings.Join(args, " "), err)
+	}
+	return out.String()
+}
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "gomdb_test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	err = os.Mkdir(src, 0755)
+	if err != nil {
+		t.Fatalf("Cannot create directory: %s", err)
+	}
+
+	run(t, "", "put", src, "a", "1")
+	run(t, "", "put", src, "b", "2")
+	run(t, "", "put", "-s", "names", "-create", "-dupsort", src, "k", "x")
+	run(t, "", "put", "-s", "names", "-x", src, "6b", "79")
+	if out := run(t, "", "get", src, "b"); out != "2\n" {
+		t.Errorf("get: %q", out)
+	}
+	if out := run(t, "", "scan", "-s", "names", src); out != "k\tx\nk\ty\n" {
+		t.Errorf("scan: %q", out)
+	}
+	run(t, "", "del", "-s", "names", src, "k", "x")
+	if out := run(t, "", "list", src); out != "names\n" {
+		t.Errorf("list: %q", out)
+	}
+	if out := run(t, "", "list", "-l", src); out != "names\t0x4\t1\n" {
+		t.Errorf("list -l: %q", out)
+	}
+	if out := run(t, "", "stat", "-a", "-f", src); !strings.Contains(out, "Status of names\n") || !strings.Contains(out, "Free pages:") {
+		t.Errorf("stat: %q", out)
+	}
+	if out := run(t, "", "readers", src); out != "(no active readers)\n" {
+		t.Errorf("readers: %q", out)
+	}
+	if out := run(t, "", "verify", src); !strings.HasPrefix(out, "2 databases, 4 entries, ") {
+		t.Errorf("verify: %q", out)
+	}
+
+	dump := run(t, "", "dump", "-a", "-p", src)
+	dst := filepath.Join(dir, "dst")
+	run(t, dump, "load", dst)
+	if out := run(t, "", "dump", "-a", "-p", dst); out != dump {
+		t.Errorf("Loaded environment differs:\n%s\nexpected:\n%s", out, dump)
+	}
+
+	cp := filepath.Join(dir, "copy")
+	err = os.Mkdir(cp, 0755)
+	if err != nil {
+		t.Fatalf("Cannot create directory: %s", err)
+	}
+	run(t, "", "copy", "-compact", src, cp)
+	if out := run(t, "", "scan", "-k", cp); out != "a\nb\nnames\n" {
+		t.Errorf("scan of the copy: %q", out)
+	}
+
+	manifest := filepath.Join(dir, "manifest")
+	full := filepath.Join(dir, "full")
+	inc := filepath.Join(dir, "inc")
+	run(t, "", "backup", "-m", manifest, "-f", full, src)
+	run(t, "", "put", src, "c", "3")
+	run(t, "", "backup", "-m", manifest, "-i", "-f", inc, src)
+	restored := filepath.Join(dir, "restored")
+	run(t, "", "restore", restored, full, inc)
+	if out := run(t, "", "scan", "-k", restored); out != "a\nb\nc\nnames\n" {
+		t.Errorf("scan of the restored environment: %q", out)
+	}
+	// A failed restore leaves the data file alone.
+	data, err := ioutil.ReadFile(inc)
+	if err != nil {
+		t.Fatalf("Cannot read backup: %s", err)
+	}
+	corrupt := filepath.Join(dir, "corrupt")
+	err = ioutil.WriteFile(corrupt, data[:len(data)-1], 0644)
+	if err != nil {
+		t.Fatalf("Cannot write backup: %s", err)
+	}
+	c := &cli{stdin: strings.NewReader(""), stdout: ioutil.Discard}
+	if c.run([]string{"restore", restored, full, corrupt}) == nil {
+		t.Errorf("Corrupt backup restored")
+	}
+	if out := run(t, "", "scan", "-k", restored); out != "a\nb\nc\nnames\n" {
+		t.Errorf("scan after a failed restore: %q", out)
+	}
+}
@@ -0,0 +1,136 @@
+/* Comparison functions passed to mdb_set_compare and mdb_set_dupsort.
+ *
+ * LMDB comparison functions carry no context pointer, so every Go comparator
+ * is bound to one of a fixed number of trampolines. Trampoline i calls back
+ * into Go with the slot number i.
+ */
+#include <string.h>
+#include <stdint.h>
+#include "_cgo_export.h"
+#include "cmp.h"
+
+#define GOMDB_TRAMPOLINE(i) \
+	static int gomdb_cmp_##i(const MDB_val *a, const MDB_val *b) \
+	{ return gomdbCompare(i, (MDB_val *)a, (MDB_val *)b); }
+
+GOMDB_TRAMPOLINE(0)
+GOMDB_TRAMPOLINE(1)
+GOMDB_TRAMPOLINE(2)
+GOMDB_TRAMPOLINE(3)
+GOMDB_TRAMPOLINE(4)
+GOMDB_TRAMPOLINE(5)
+GOMDB_TRAMPOLINE(6)
+GOMDB_TRAMPOLINE(7)
+GOMDB_TRAMPOLINE(8)
+GOMDB_TRAMPOLINE(9)
+GOMDB_TRAMPOLINE(10)
+GOMDB_TRAMPOLINE(11)
+GOMDB_TRAMPOLINE(12)
+GOMDB_TRAMPOLINE(13)
+GOMDB_TRAMPOLINE(14)
+GOMDB_TRAMPOLINE(15)
+GOMDB_TRAMPOLINE(16)
+GOMDB_TRAMPOLINE(17)
+GOMDB_TRAMPOLINE(18)
+GOMDB_TRAMPOLINE(19)
+GOMDB_TRAMPOLINE(20)
+GOMDB_TRAMPOLINE(21)
+GOMDB_TRAMPOLINE(22)
+GOMDB_TRAMPOLINE(23)
+GOMDB_TRAMPOLINE(24)
+GOMDB_TRAMPOLINE(25)
+GOMDB_TRAMPOLINE(26)
+GOMDB_TRAMPOLINE(27)
+GOMDB_TRAMPOLINE(28)
+GOMDB_TRAMPOLINE(29)
+GOMDB_TRAMPOLINE(30)
+GOMDB_TRAMPOLINE(31)
+
+static MDB_cmp_func *gomdb_cmp_slots[GOMDB_CMP_SLOTS] = {
+	gomdb_cmp_0, gomdb_cmp_1, gomdb_cmp_2, gomdb_cmp_3,
+	gomdb_cmp_4, gomdb_cmp_5, gomdb_cmp_6, gomdb_cmp_7,
+	gomdb_cmp_8, gomdb_cmp_9, gomdb_cmp_10, gomdb_cmp_11,
+	gomdb_cmp_12, gomdb_cmp_13, gomdb_cmp_14, gomdb_cmp_15,
+	gomdb_cmp_16, gomdb_cmp_17, gomdb_cmp_18, gomdb_cmp_19,
+	gomdb_cmp_20, gomdb_cmp_21, gomdb_cmp_22, gomdb_cmp_23,
+	gomdb_cmp_24, gomdb_cmp_25, gomdb_cmp_26, gomdb_cmp_27,
+	gomdb_cmp_28, gomdb_cmp_29, gomdb_cmp_30, gomdb_cmp_31,
+};
+
+MDB_cmp_func *
+gomdb_cmp_slot(int slot)
+{
+	if (slot < 0 || slot >= GOMDB_CMP_SLOTS)
+		return NULL;
+	return gomdb_cmp_slots[slot];
+}
+
+static int
+gomdb_cmp_bytes(const MDB_val *a, const MDB_val *b)
+{
+	size_t len = a->mv_size < b->mv_size ? a->mv_size : b->mv_size;
+	int diff = memcmp(a->mv_data, b->mv_data, len);
+	if (diff)
+		return diff;
+	return a->mv_size < b->mv_size ? -1 : a->mv_size > b->mv_size;
+}
+
+static uint64_t
+gomdb_be64(const MDB_val *v)
+{
+	const unsigned char *p = v->mv_data;
+	uint64_t u = 0;
+	int i;
+	for (i = 0; i < 8; i++)
+		u = u << 8 | p[i];
+	return u;
+}
+
+/* Keys are 8-byte big-endian two's complement integers. */
+static int
+gomdb_cmp_int64(const MDB_val *a, const MDB_val *b)
+{
+	int64_t x, y;
+	if (a->mv_size != 8 || b->mv_size != 8)
+		return gomdb_cmp_bytes(a, b);
+	x = (int64_t)gomdb_be64(a);
+	y = (int64_t)gomdb_be64(b);
+	return x < y ? -1 : x > y;
+}
+
+/* Keys are 8-byte big-endian IEEE 754 doubles. The bits are mapped so that
+ * unsigned order is the IEEE total order, which also orders NaNs.
+ */
+static int
+gomdb_cmp_float64(const MDB_val *a, const MDB_val *b)
+{
+	uint64_t x, y;
+	if (a->mv_size != 8 || b->mv_size != 8)
+		return gomdb_cmp_bytes(a, b);
+	x = gomdb_be64(a);
+	y = gomdb_be64(b);
+	x ^= (x >> 63) ? ~(uint64_t)0 : (uint64_t)1 << 63;
+	y ^= (y >> 63) ? ~(uint64_t)0 : (uint64_t)1 << 63;
+	return x < y ? -1 : x > y;
+}
+
+/* Keys are ordered by descending lexicographic byte order. */
+static int
+gomdb_cmp_reverse_bytes(const MDB_val *a, const MDB_val *b)
+{
+	return gomdb_cmp_bytes(b, a);
+}
+
+MDB_cmp_func *
+gomdb_cmp_builtin(int which)
+{
+	switch (which) {
+	case GOMDB_CMP_INT64:
+		return gomdb_cmp_int64;
+	case GOMDB_CMP_FLOAT64:
+		return gomdb_cmp_float64;
+	case GOMDB_CMP_REVERSE_BYTES:
+		return gomdb_cmp_reverse_bytes;
+	}
+	return NULL;
+}
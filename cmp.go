@@ -0,0 +1,181 @@
+package mdb
+
+/*
+#cgo CFLAGS: -pthread -W -Wall -Wno-unused-parameter -Wbad-function-cast -O2 -g
+#include "lmdb.h"
+#include "cmp.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+)
+
+// CmpFunc compares two keys or two duplicate data items and returns a
+// negative number, zero or a positive number when a sorts before, equal to or
+// after b. The slices point into LMDB memory and must not be retained or
+// modified.
+type CmpFunc func(a, b []byte) int
+
+// Comparator identifies a comparison function implemented in C. Builtin
+// comparators avoid the cost of calling back into Go for every comparison.
+type Comparator int
+
+const (
+	CmpInt64        Comparator = C.GOMDB_CMP_INT64         // 8-byte big-endian signed integers
+	CmpFloat64      Comparator = C.GOMDB_CMP_FLOAT64       // 8-byte big-endian IEEE 754 doubles
+	CmpReverseBytes Comparator = C.GOMDB_CMP_REVERSE_BYTES // descending lexicographic byte order
+)
+
+// Go comparators are registered per environment, DBI and kind and bound to a
+// C trampoline slot.
+type cmpKey struct {
+	env *C.MDB_env
+	dbi DBI
+	dup bool
+}
+
+var cmpRegistry = struct {
+	sync.RWMutex
+	slots [C.GOMDB_CMP_SLOTS]CmpFunc
+	keys  map[cmpKey]int
+	set   map[cmpKey]bool // all comparators set, including builtin ones
+}{keys: map[cmpKey]int{}, set: map[cmpKey]bool{}}
+
+//export gomdbCompare
+func gomdbCompare(slot C.int, a, b *C.MDB_val) C.int {
+	cmpRegistry.RLock()
+	cmp := cmpRegistry.slots[slot]
+	cmpRegistry.RUnlock()
+	return C.int(cmp(Val(*a).BytesNoCopy(), Val(*b).BytesNoCopy()))
+}
+
+// registerCmp installs cmp in the slot of key and returns the comparator
+// the slot held before, or nil if key had no slot.
+func registerCmp(key cmpKey, cmp CmpFunc) (*C.MDB_cmp_func, CmpFunc, error) {
+	cmpRegistry.Lock()
+	defer cmpRegistry.Unlock()
+	var prev CmpFunc
+	slot, ok := cmpRegistry.keys[key]
+	if ok {
+		prev = cmpRegistry.slots[slot]
+	} else {
+		slot = -1
+		for i, f := range cmpRegistry.slots {
+			if f == nil {
+				slot = i
+				break
+			}
+		}
+		if slot < 0 {
+			return nil, nil, errors.New("No free comparator slot")
+		}
+		cmpRegistry.keys[key] = slot
+	}
+	cmpRegistry.slots[slot] = cmp
+	return C.gomdb_cmp_slot(C.int(slot)), prev, nil
+}
+
+// unregisterCmp puts prev back into the slot of key, or frees the slot if
+// prev is nil.
+func unregisterCmp(key cmpKey, prev CmpFunc) {
+	cmpRegistry.Lock()
+	defer cmpRegistry.Unlock()
+	slot, ok := cmpRegistry.keys[key]
+	if !ok {
+		return
+	}
+	cmpRegistry.slots[slot] = prev
+	if prev == nil {
+		delete(cmpRegistry.keys, key)
+	}
+}
+
+// releaseCmps frees the comparator slots held by env. If all is false only
+// the slots of dbi are freed.
+func releaseCmps(env *C.MDB_env, dbi DBI, all bool) {
+	cmpRegistry.Lock()
+	defer cmpRegistry.Unlock()
+	for key, slot := range cmpRegistry.keys {
+		if key.env == env && (all || key.dbi == dbi) {
+			cmpRegistry.slots[slot] = nil
+			delete(cmpRegistry.keys, key)
+		}
+	}
+	for key := range cmpRegistry.set {
+		if key.env == env && (all || key.dbi == dbi) {
+			delete(cmpRegistry.set, key)
+		}
+	}
+}
+
+// hasCmp reports whether a comparator was set for the keys, or the
+// duplicates if dup is true, of dbi in env.
+func hasCmp(env *C.MDB_env, dbi DBI, dup bool) bool {
+	cmpRegistry.RLock()
+	defer cmpRegistry.RUnlock()
+	return cmpRegistry.set[cmpKey{env, dbi, dup}]
+}
+
+func (txn *Txn) setCmp(dbi DBI, dup bool, cmp *C.MDB_cmp_func) error {
+	var ret C.int
+	if dup {
+		ret = C.mdb_set_dupsort(txn._txn, C.MDB_dbi(dbi), cmp)
+	} else {
+		ret = C.mdb_set_compare(txn._txn, C.MDB_dbi(dbi), cmp)
+	}
+	if ret == SUCCESS {
+		cmpRegistry.Lock()
+		cmpRegistry.set[cmpKey{C.mdb_txn_env(txn._txn), dbi, dup}] = true
+		cmpRegistry.Unlock()
+	}
+	return errno(ret)
+}
+
+func (txn *Txn) setGoCmp(dbi DBI, dup bool, cmp CmpFunc) error {
+	key := cmpKey{C.mdb_txn_env(txn._txn), dbi, dup}
+	fn, prev, err := registerCmp(key, cmp)
+	if err != nil {
+		return err
+	}
+	err = txn.setCmp(dbi, dup, fn)
+	if err != nil {
+		unregisterCmp(key, prev)
+	}
+	return err
+}
+
+// Set a custom key comparison function for a database. The function must be
+// set before any data access and be the same every time the database is
+// used. Only a limited number of Go comparators can be installed at once;
+// they are released when the DBI or the environment is closed.
+func (txn *Txn) SetCompare(dbi DBI, cmp CmpFunc) error {
+	return txn.setGoCmp(dbi, false, cmp)
+}
+
+// Set a custom data comparison function for a DUPSORT database. See
+// SetCompare.
+func (txn *Txn) SetDupSort(dbi DBI, cmp CmpFunc) error {
+	return txn.setGoCmp(dbi, true, cmp)
+}
+
+func (txn *Txn) setBuiltinCmp(dbi DBI, dup bool, cmp Comparator) error {
+	fn := C.gomdb_cmp_builtin(C.int(cmp))
+	if fn == nil {
+		return syscall.EINVAL
+	}
+	return txn.setCmp(dbi, dup, fn)
+}
+
+// Set a builtin key comparison function for a database. See SetCompare.
+func (txn *Txn) SetCompareBuiltin(dbi DBI, cmp Comparator) error {
+	return txn.setBuiltinCmp(dbi, false, cmp)
+}
+
+// Set a builtin data comparison function for a DUPSORT database. See
+// SetCompare.
+func (txn *Txn) SetDupSortBuiltin(dbi DBI, cmp Comparator) error {
+	return txn.setBuiltinCmp(dbi, true, cmp)
+}
@@ -0,0 +1,16 @@
+#ifndef GOMDB_CMP_H
+#define GOMDB_CMP_H
+
+#include "lmdb.h"
+
+/* Number of Go comparators that can be installed at the same time. */
+#define GOMDB_CMP_SLOTS	32
+
+#define GOMDB_CMP_INT64	0
+#define GOMDB_CMP_FLOAT64	1
+#define GOMDB_CMP_REVERSE_BYTES	2
+
+MDB_cmp_func *gomdb_cmp_slot(int slot);
+MDB_cmp_func *gomdb_cmp_builtin(int which);
+
+#endif
@@ -0,0 +1,160 @@
+package mdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func scanKeyVals(t *testing.T, txn *Txn, dbi DBI) (keys, vals [][]byte) {
+	cursor, err := txn.CursorOpen(dbi)
+	if err != nil {
+		t.Fatalf("Cannot open cursor: %s", err)
+	}
+	defer cursor.Close()
+	for {
+		k, v, err := cursor.Get(nil, nil, NEXT)
+		if err == NotFound {
+			return keys, vals
+		}
+		if err != nil {
+			t.Fatalf("Cannot scan database: %s", err)
+		}
+		keys = append(keys, k)
+		vals = append(vals, v)
+	}
+}
+
+func TestSetCompare(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	txn, err := env.BeginTxn(nil, 0)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction: %s", err)
+	}
+	defer txn.Abort()
+	dbi, err := txn.DBIOpen(nil, DUPSORT)
+	if err != nil {
+		t.Fatalf("Cannot open DBI: %s", err)
+	}
+	err = txn.SetCompare(dbi, func(a, b []byte) int {
+		return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+	})
+	if err != nil {
+		t.Fatalf("Cannot set compare: %s", err)
+	}
+	err = txn.SetDupSort(dbi, func(a, b []byte) int {
+		return -bytes.Compare(a, b)
+	})
+	if err != nil {
+		t.Fatalf("Cannot set dupsort: %s", err)
+	}
+	for _, kv := range [][2]string{{"b", "1"}, {"A", "1"}, {"D", "1"}, {"b", "2"}, {"c", "3"}} {
+		err = txn.Put(dbi, []byte(kv[0]), []byte(kv[1]), 0)
+		if err != nil {
+			t.Fatalf("Cannot put: %s", err)
+		}
+	}
+	keys, vals := scanKeyVals(t, txn, dbi)
+	expected := [][2]string{{"A", "1"}, {"b", "2"}, {"b", "1"}, {"c", "3"}, {"D", "1"}}
+	if len(keys) != len(expected) {
+		t.Fatalf("Unexpected number of entries: %d", len(keys))
+	}
+	for i, kv := range expected {
+		if string(keys[i]) != kv[0] || string(vals[i]) != kv[1] {
+			t.Errorf("Entry %d: %s=%s, expected %s=%s", i, keys[i], vals[i], kv[0], kv[1])
+		}
+	}
+}
+
+func TestSetCompareBuiltin(t *testing.T) {
+	env := setupMaxDBs(t, 3)
+	defer clean(env, t)
+
+	be := func(u uint64) []byte {
+		p := make([]byte, 8)
+		binary.BigEndian.PutUint64(p, u)
+		return p
+	}
+	tests := []struct {
+		name string
+		cmp  Comparator
+		keys [][]byte // in expected order
+	}{
+		{"int64", CmpInt64, [][]byte{
+			be(1 << 63), be(math.MaxUint64 - 9), be(0), be(7), be(math.MaxInt64)}},
+		{"float64", CmpFloat64, [][]byte{
+			be(math.Float64bits(math.Inf(-1))), be(math.Float64bits(-2.5)), be(math.Float64bits(0)),
+			be(math.Float64bits(0.125)), be(math.Float64bits(1e300))}},
+		{"reverse", CmpReverseBytes, [][]byte{
+			[]byte("c"), []byte("bb"), []byte("b"), []byte("ab"), []byte("a")}},
+	}
+	txn, err := env.BeginTxn(nil, 0)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction: %s", err)
+	}
+	defer txn.Abort()
+	for _, test := range tests {
+		dbi, err := txn.DBIOpen(&test.name, CREATE)
+		if err != nil {
+			t.Fatalf("Cannot open DBI: %s", err)
+		}
+		err = txn.SetCompareBuiltin(dbi, test.cmp)
+		if err != nil {
+			t.Fatalf("Cannot set compare: %s", err)
+		}
+		for i := len(test.keys) - 1; i >= 0; i-- {
+			err = txn.Put(dbi, test.keys[i], nil, 0)
+			if err != nil {
+				t.Fatalf("Cannot put: %s", err)
+			}
+		}
+		keys, _ := scanKeyVals(t, txn, dbi)
+		if len(keys) != len(test.keys) {
+			t.Fatalf("%s: unexpected number of entries: %d", test.name, len(keys))
+		}
+		for i := range keys {
+			if !bytes.Equal(keys[i], test.keys[i]) {
+				t.Errorf("%s: entry %d is %x, expected %x", test.name, i, keys[i], test.keys[i])
+			}
+		}
+	}
+}
+
+func TestUnregisterCmp(t *testing.T) {
+	byteCmp := func(a, b []byte) int { return bytes.Compare(a, b) }
+	dupKey := cmpKey{nil, 1, true}
+	_, _, err := registerCmp(cmpKey{nil, 1, false}, byteCmp)
+	if err != nil {
+		t.Fatalf("Cannot register comparator: %s", err)
+	}
+	defer releaseCmps(nil, 0, true)
+	_, prev, err := registerCmp(dupKey, byteCmp)
+	if err != nil || prev != nil {
+		t.Fatalf("Cannot register comparator: %v %v", prev != nil, err)
+	}
+	// A failed replacement puts the previous comparator back.
+	_, prev, err = registerCmp(dupKey, func(a, b []byte) int { return 0 })
+	if err != nil || prev == nil {
+		t.Fatalf("Cannot replace comparator: %v %v", prev != nil, err)
+	}
+	unregisterCmp(dupKey, prev)
+	cmpRegistry.RLock()
+	slot, ok := cmpRegistry.keys[dupKey]
+	restored := ok && cmpRegistry.slots[slot]([]byte("a"), []byte("b")) < 0
+	cmpRegistry.RUnlock()
+	if !restored {
+		t.Errorf("Previous comparator not restored")
+	}
+	// A failed first registration frees only its own slot.
+	unregisterCmp(dupKey, nil)
+	cmpRegistry.RLock()
+	_, dupOK := cmpRegistry.keys[dupKey]
+	_, keyOK := cmpRegistry.keys[cmpKey{nil, 1, false}]
+	cmpRegistry.RUnlock()
+	if dupOK || !keyOK {
+		t.Errorf("dup slot registered %v, key slot registered %v", dupOK, keyOK)
+	}
+}
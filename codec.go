@@ -0,0 +1,140 @@
+//go:build go1.18
+
+package mdb
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec converts values of type T to and from their stored form.
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// KeyCodec is a Codec whose encoded values sort, under the default byte-wise
+// key comparison, in the natural order of T. Only KeyCodecs can encode the
+// keys of a Bucket.
+type KeyCodec[T any] interface {
+	Codec[T]
+	// OrderPreserving marks the codec as preserving the order of T.
+	OrderPreserving()
+}
+
+// Uint64Codec encodes uint64 values as 8 big-endian bytes.
+type Uint64Codec struct{}
+
+func (Uint64Codec) Encode(v uint64) ([]byte, error) {
+	p := make([]byte, 8)
+	binary.BigEndian.PutUint64(p, v)
+	return p, nil
+}
+
+func (Uint64Codec) Decode(data []byte) (uint64, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("Invalid uint64 length %d", len(data))
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+func (Uint64Codec) OrderPreserving() {}
+
+// Int64Codec encodes int64 values as 8 big-endian bytes with the sign bit
+// flipped, so negative values sort before positive ones.
+type Int64Codec struct{}
+
+func (Int64Codec) Encode(v int64) ([]byte, error) {
+	p := make([]byte, 8)
+	binary.BigEndian.PutUint64(p, uint64(v)^1<<63)
+	return p, nil
+}
+
+func (Int64Codec) Decode(data []byte) (int64, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("Invalid int64 length %d", len(data))
+	}
+	return int64(binary.BigEndian.Uint64(data) ^ 1<<63), nil
+}
+
+func (Int64Codec) OrderPreserving() {}
+
+// StringCodec stores strings as their bytes.
+type StringCodec struct{}
+
+func (StringCodec) Encode(v string) ([]byte, error) {
+	return []byte(v), nil
+}
+
+func (StringCodec) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
+
+func (StringCodec) OrderPreserving() {}
+
+// BytesCodec stores byte slices unchanged.
+type BytesCodec struct{}
+
+func (BytesCodec) Encode(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+func (BytesCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (BytesCodec) OrderPreserving() {}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// GobCodec encodes values with encoding/gob. Every value is encoded with
+// its own type information.
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// BinaryCodec encodes values whose pointers implement
+// encoding.BinaryMarshaler and encoding.BinaryUnmarshaler, such as
+// generated protocol buffer messages with binary marshalers or time.Time:
+//
+//	BinaryCodec[time.Time, *time.Time]{}
+type BinaryCodec[T any, PT interface {
+	*T
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}] struct{}
+
+func (BinaryCodec[T, PT]) Encode(v T) ([]byte, error) {
+	return PT(&v).MarshalBinary()
+}
+
+func (BinaryCodec[T, PT]) Decode(data []byte) (T, error) {
+	var v T
+	err := PT(&v).UnmarshalBinary(data)
+	return v, err
+}
@@ -0,0 +1,45 @@
+package mdb
+
+import (
+	"context"
+)
+
+// BeginTxnContext is like BeginTxn but waits for the write transactions of
+// other goroutines of this process only until ctx is done, in which case
+// ctx.Err() is returned. The wait for the lock file mutex in
+// mdb_txn_begin, which is held by the writers of other processes, cannot
+// be interrupted, so a deadline on ctx does not bound it. Iterators of the
+// returned transaction stop when ctx is done; see Txn.Iterator.
+func (env *Env) BeginTxnContext(ctx context.Context, parent *Txn, flags uint) (*Txn, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	return env.beginTxn(ctx, parent, flags)
+}
+
+// UpdateContext is like Update but the transaction is begun with
+// BeginTxnContext, and it is aborted and ctx.Err() returned if ctx is done
+// when op returns.
+func (env *Env) UpdateContext(ctx context.Context, op TxnOp) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	return env.update(ctx, op)
+}
+
+// ViewContext is like View but the transaction is begun with
+// BeginTxnContext, and ctx.Err() is returned if ctx is done when op returns.
+func (env *Env) ViewContext(ctx context.Context, op TxnOp) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	return env.run(ctx, RDONLY, op)
+}
+
+// Context returns the context the transaction was begun with, or nil.
+func (txn *Txn) Context() context.Context {
+	return txn.ctx
+}
@@ -0,0 +1,96 @@
+package mdb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBeginTxnContextDeadline(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	txn, err := env.BeginTxn(nil, 0)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = env.BeginTxnContext(ctx, nil, 0)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected a deadline error while the write lock is held: %v", err)
+	}
+	txn.Abort()
+
+	txn, err = env.BeginTxnContext(context.Background(), nil, 0)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction after the lock was released: %s", err)
+	}
+	txn.Abort()
+}
+
+func TestViewContextCancel(t *testing.T) {
+	env, dbi := setupIterDB(t, 0, "a=1", "b=2", "c=3", "d=4")
+	defer clean(env, t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var keys []string
+	err := env.ViewContext(ctx, func(txn *Txn) error {
+		it, err := txn.Iterator(dbi, nil)
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		for it.Next() {
+			keys = append(keys, string(it.Key()))
+			if len(keys) == 2 {
+				cancel()
+			}
+		}
+		// The transaction is left to its owner.
+		val, err := txn.Get(dbi, []byte("d"))
+		if err != nil || string(val) != "4" {
+			t.Errorf("Get after cancel: %q, %v", val, err)
+		}
+		return it.Err()
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("Iteration did not stop after cancel: %q", keys)
+	}
+	err = env.ViewContext(ctx, func(txn *Txn) error {
+		t.Errorf("View ran with a canceled context")
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled: %v", err)
+	}
+}
+
+func TestUpdateContextCancel(t *testing.T) {
+	env, dbi := setupIterDB(t, 0)
+	defer clean(env, t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := env.UpdateContext(ctx, func(txn *Txn) error {
+		err := txn.Put(dbi, []byte("key"), []byte("val"), 0)
+		cancel()
+		return err
+	})
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled: %v", err)
+	}
+	err = env.View(func(txn *Txn) error {
+		_, err := txn.Get(dbi, []byte("key"))
+		if err != NotFound {
+			t.Errorf("Canceled transaction was committed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
@@ -8,11 +8,33 @@ package mdb
 #include <stdlib.h>
 #include <stdio.h>
 #include "lmdb.h"
+
+// Get a page of DUPFIXED items and the size of each item. If next is zero the
+// cursor is positioned at key first.
+static int gomdb_cursor_get_multiple(MDB_cursor *cur, MDB_val *key, MDB_val *data, size_t *stride, int next) {
+	MDB_val k, v;
+	int rc;
+	if (next) {
+		rc = mdb_cursor_get(cur, key, data, MDB_NEXT_MULTIPLE);
+	} else {
+		rc = mdb_cursor_get(cur, key, data, MDB_SET_KEY);
+		if (rc == MDB_SUCCESS)
+			rc = mdb_cursor_get(cur, key, data, MDB_GET_MULTIPLE);
+	}
+	if (rc != MDB_SUCCESS)
+		return rc;
+	rc = mdb_cursor_get(cur, &k, &v, MDB_GET_CURRENT);
+	if (rc != MDB_SUCCESS)
+		return rc;
+	*stride = v.mv_size;
+	return MDB_SUCCESS;
+}
 */
 import "C"
 
 import (
 	"errors"
+	"unsafe"
 )
 
 // MDB_cursor_op
@@ -47,10 +69,13 @@ func (cursor *Cursor) Close() error {
 }
 
 func (cursor *Cursor) Txn() *Txn {
+	if cursor.txn != nil {
+		return cursor.txn
+	}
 	var _txn *C.MDB_txn
 	_txn = C.mdb_cursor_txn(cursor._cursor)
 	if _txn != nil {
-		return &Txn{_txn}
+		return &Txn{_txn: _txn}
 	}
 	return nil
 }
@@ -85,14 +110,42 @@ func (cursor *Cursor) Put(key, val []byte, flags uint) error {
 	ckey := Wrap(key)
 	cval := Wrap(val)
 	ret := C.mdb_cursor_put(cursor._cursor, (*C.MDB_val)(&ckey), (*C.MDB_val)(&cval), C.uint(flags))
+	if ret == SUCCESS && cursor.changes() != nil {
+		cursor.changes().add(cursor.txn, cursor.DBI(), ChangePut, key, val)
+	}
 	return errno(ret)
 }
 
 func (cursor *Cursor) Del(flags uint) error {
+	changes := cursor.changes()
+	var key, val []byte
+	if changes != nil {
+		// Record the entry before the cursor moves on.
+		k, v, err := cursor.Get(nil, nil, GET_CURRENT)
+		if err != nil {
+			return err
+		}
+		key = k
+		dbflags, _ := cursor.txn.Flags(cursor.DBI())
+		if dbflags&DUPSORT != 0 && flags&NODUPDATA == 0 {
+			val = v
+		}
+	}
 	ret := C.mdb_cursor_del(cursor._cursor, C.uint(flags))
+	if ret == SUCCESS && changes != nil {
+		changes.add(cursor.txn, cursor.DBI(), ChangeDel, key, val)
+	}
 	return errno(ret)
 }
 
+// changes returns the writes recorded by the transaction of the cursor.
+func (cursor *Cursor) changes() *txnChanges {
+	if cursor.txn == nil {
+		return nil
+	}
+	return cursor.txn.changes
+}
+
 func (cursor *Cursor) Count() (uint64, error) {
 	var _size C.size_t
 	ret := C.mdb_cursor_count(cursor._cursor, &_size)
@@ -101,3 +154,73 @@ func (cursor *Cursor) Count() (uint64, error) {
 	}
 	return uint64(_size), nil
 }
+
+// Multiple is a page of fixed-size duplicate data items read from a DUPFIXED
+// database with Cursor.GetMultiple or Cursor.NextMultiple.
+type Multiple struct {
+	Stride int    // Size of each item.
+	Data   []byte // The items, stored back to back.
+}
+
+// Len returns the number of items in the page.
+func (m Multiple) Len() int {
+	if m.Stride == 0 {
+		return 0
+	}
+	return len(m.Data) / m.Stride
+}
+
+// Values splits the page into its items. The items share memory with Data.
+func (m Multiple) Values() [][]byte {
+	vals := make([][]byte, m.Len())
+	for i := range vals {
+		vals[i] = m.Data[i*m.Stride : (i+1)*m.Stride : (i+1)*m.Stride]
+	}
+	return vals
+}
+
+// Uint32s returns the items as integers in native byte order, as stored in
+// INTEGERDUP databases. It returns nil if the items are not 4 bytes long.
+func (m Multiple) Uint32s() []uint32 {
+	if m.Stride != 4 || m.Len() == 0 {
+		return nil
+	}
+	vals := make([]uint32, m.Len())
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(&vals[0])), len(vals)*4), m.Data)
+	return vals
+}
+
+// Uint64s returns the items as integers in native byte order, as stored in
+// INTEGERDUP databases. It returns nil if the items are not 8 bytes long.
+func (m Multiple) Uint64s() []uint64 {
+	if m.Stride != 8 || m.Len() == 0 {
+		return nil
+	}
+	vals := make([]uint64, m.Len())
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(&vals[0])), len(vals)*8), m.Data)
+	return vals
+}
+
+// GetMultiple positions the cursor at key and returns the first page of its
+// duplicate data items. The database must be opened with DUPFIXED. Further
+// pages are read with NextMultiple.
+func (cursor *Cursor) GetMultiple(key []byte) (Multiple, error) {
+	return cursor.getMultiple(key, 0)
+}
+
+// NextMultiple returns the next page of duplicate data items of the current
+// key. NotFound is returned when all items of the key have been read.
+func (cursor *Cursor) NextMultiple() (Multiple, error) {
+	return cursor.getMultiple(nil, 1)
+}
+
+func (cursor *Cursor) getMultiple(key []byte, next C.int) (Multiple, error) {
+	ckey := Wrap(key)
+	var cval Val
+	var stride C.size_t
+	ret := C.gomdb_cursor_get_multiple(cursor._cursor, (*C.MDB_val)(&ckey), (*C.MDB_val)(&cval), &stride, next)
+	if ret != SUCCESS {
+		return Multiple{}, errno(ret)
+	}
+	return Multiple{Stride: int(stride), Data: cval.Bytes()}, nil
+}
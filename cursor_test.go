@@ -0,0 +1,105 @@
+package mdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unsafe"
+)
+
+func TestCursorGetMultiple(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	const n = 2000
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.DBIOpen(nil, DUPSORT|DUPFIXED|INTEGERDUP)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			val := make([]byte, 8)
+			*(*uint64)(unsafe.Pointer(&val[0])) = uint64(i) << 8
+			err = txn.Put(dbi, []byte("many"), val, 0)
+			if err != nil {
+				return err
+			}
+		}
+		return txn.Put(dbi, []byte("one"), []byte("1234"), 0)
+	})
+	if err != nil {
+		t.Fatalf("Cannot fill database: %s", err)
+	}
+
+	err = env.View(func(txn *Txn) error {
+		cursor, err := txn.CursorOpen(dbi)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close()
+
+		var vals []uint64
+		m, err := cursor.GetMultiple([]byte("many"))
+		for err == nil {
+			if m.Stride != 8 || m.Len() == 0 || len(m.Values()) != m.Len() {
+				t.Fatalf("Unexpected page: stride %d, len %d", m.Stride, m.Len())
+			}
+			vals = append(vals, m.Uint64s()...)
+			m, err = cursor.NextMultiple()
+		}
+		if err != NotFound {
+			return err
+		}
+		if len(vals) != n {
+			t.Fatalf("Read %d values, expected %d", len(vals), n)
+		}
+		for i, v := range vals {
+			if v != uint64(i)<<8 {
+				t.Fatalf("Value %d is %d", i, v)
+			}
+		}
+
+		m, err = cursor.GetMultiple([]byte("one"))
+		if err != nil {
+			return err
+		}
+		if m.Len() != 1 || string(m.Values()[0]) != "1234" || m.Uint32s() == nil || m.Uint64s() != nil {
+			t.Errorf("Unexpected page for a single value: %+v", m)
+		}
+		_, err = cursor.NextMultiple()
+		if err != NotFound {
+			t.Errorf("Expected NotFound after a single value: %v", err)
+		}
+
+		_, err = cursor.GetMultiple([]byte("none"))
+		if err != NotFound {
+			t.Errorf("Expected NotFound for a missing key: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestMultipleValues(t *testing.T) {
+	data := []byte("aaabbbccc")
+	m := Multiple{Stride: 3, Data: data}
+	vals := m.Values()
+	if len(vals) != 3 || !bytes.Equal(vals[1], []byte("bbb")) {
+		t.Errorf("Unexpected values: %q", vals)
+	}
+	vals[0] = append(vals[0], 'x')
+	if !bytes.Equal(data, []byte("aaabbbccc")) {
+		t.Errorf("Appending to a value modified the page: %q", data)
+	}
+	data = make([]byte, 8)
+	binary.BigEndian.PutUint32(data[4:], 1)
+	if m := (Multiple{Stride: 4, Data: data}); len(m.Uint32s()) != 2 {
+		t.Errorf("Unexpected integers: %v", m.Uint32s())
+	}
+	if m := (Multiple{}); m.Len() != 0 || m.Uint64s() != nil {
+		t.Errorf("Unexpected empty page: %+v", m)
+	}
+}
@@ -0,0 +1,398 @@
+package mdb
+
+/*
+#cgo CFLAGS: -pthread -W -Wall -Wno-unused-parameter -Wbad-function-cast -O2 -g
+#include "lmdb.h"
+*/
+import "C"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// DumpOptions controls the output of Dump.
+type DumpOptions struct {
+	Print bool // Write printable bytes as they are, like mdb_dump -p.
+}
+
+// The database flags in the order mdb_dump writes them.
+var dumpFlags = []struct {
+	flag uint
+	name string
+}{
+	{REVERSEKEY, "reversekey"},
+	{DUPSORT, "dupsort"},
+	{INTEGERKEY, "integerkey"},
+	{DUPFIXED, "dupfixed"},
+	{INTEGERDUP, "integerdup"},
+	{REVERSEDUP, "reversedup"},
+}
+
+// loadBatchSize is the number of records Load writes per transaction.
+const loadBatchSize = 1000
+
+// Dump writes the entries of dbi to w in the text format of mdb_dump, which
+// Load and mdb_load read.
+func Dump(txn *Txn, dbi DBI, w io.Writer) error {
+	return DumpWithOptions(txn, dbi, w, DumpOptions{})
+}
+
+// DumpWithOptions is like Dump with options.
+func DumpWithOptions(txn *Txn, dbi DBI, w io.Writer, opts DumpOptions) error {
+	bw := bufio.NewWriter(w)
+	name, _ := txn.dbiName(dbi)
+	err := dumpDB(txn, dbi, name, nil, bw, opts)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// DumpAll writes all databases of the environment to w like mdb_dump -a.
+// Entries of the main database that are not named databases are written
+// first. Named databases are opened, so SetMaxDBs must allow for all of
+// them.
+func DumpAll(txn *Txn, w io.Writer, opts DumpOptions) error {
+	main, err := txn.DBIOpen(nil, 0)
+	if err != nil {
+		return err
+	}
+	names, plain, err := txn.namedDBs(main)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if plain {
+		skip := map[string]bool{}
+		for _, name := range names {
+			skip[name] = true
+		}
+		err = dumpDB(txn, main, "", skip, bw, opts)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		dbi, err := txn.DBIOpen(&name, 0)
+		if err != nil {
+			return err
+		}
+		err = dumpDB(txn, dbi, name, nil, bw, opts)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// dumpDB writes the header and the entries of dbi, leaving out the keys in
+// skip.
+func dumpDB(txn *Txn, dbi DBI, name string, skip map[string]bool, w *bufio.Writer, opts DumpOptions) error {
+	var info C.MDB_envinfo
+	ret := C.mdb_env_info(C.mdb_txn_env(txn._txn), &info)
+	if ret != SUCCESS {
+		return errno(ret)
+	}
+	stat, err := txn.Stat(dbi)
+	if err != nil {
+		return err
+	}
+	flags, err := txn.Flags(dbi)
+	if err != nil {
+		return err
+	}
+	format := "bytevalue"
+	if opts.Print {
+		format = "print"
+	}
+	fmt.Fprintf(w, "VERSION=3\nformat=%s\n", format)
+	if name != "" {
+		fmt.Fprintf(w, "database=%s\n", name)
+	}
+	fmt.Fprintf(w, "type=btree\nmapsize=%d\n", uint64(info.me_mapsize))
+	if info.me_mapaddr != nil {
+		fmt.Fprintf(w, "mapaddr=%p\n", info.me_mapaddr)
+	}
+	fmt.Fprintf(w, "maxreaders=%d\n", uint(info.me_maxreaders))
+	if flags&DUPSORT != 0 {
+		fmt.Fprintf(w, "duplicates=1\n")
+	}
+	for _, f := range dumpFlags {
+		if flags&f.flag != 0 {
+			fmt.Fprintf(w, "%s=1\n", f.name)
+		}
+	}
+	fmt.Fprintf(w, "db_pagesize=%d\nHEADER=END\n", stat.PSize)
+
+	cursor, err := txn.CursorOpen(dbi)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	key, val, err := cursor.GetVal(nil, nil, NEXT)
+	for err == nil {
+		k := key.BytesNoCopy()
+		if !skip[string(k)] {
+			dumpVal(w, k, opts.Print)
+			dumpVal(w, val.BytesNoCopy(), opts.Print)
+		}
+		key, val, err = cursor.GetVal(nil, nil, NEXT)
+	}
+	if err != NotFound {
+		return err
+	}
+	_, err = w.WriteString("DATA=END\n")
+	return err
+}
+
+const hexDigits = "0123456789abcdef"
+
+func dumpVal(w *bufio.Writer, p []byte, print bool) {
+	w.WriteByte(' ')
+	for _, c := range p {
+		switch {
+		case !print:
+			w.WriteByte(hexDigits[c>>4])
+			w.WriteByte(hexDigits[c&0xf])
+		case c == '\\':
+			w.WriteString(`\\`)
+		case c >= 0x20 && c < 0x7f:
+			w.WriteByte(c)
+		default:
+			w.WriteByte('\\')
+			w.WriteByte(hexDigits[c>>4])
+			w.WriteByte(hexDigits[c&0xf])
+		}
+	}
+	w.WriteByte('\n')
+}
+
+// Load reads databases written by Dump or mdb_dump from r and stores their
+// entries in env, creating the databases as needed. The memory map is grown
+// to the map size of the dump if it is smaller. Entries are written in
+// transactions of a limited size, so a failed Load may leave some entries
+// of a database loaded.
+func Load(env *Env, r io.Reader) error {
+	l := &loader{r: bufio.NewReader(r)}
+	for {
+		h, err := l.header()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = l.load(env, h)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type loader struct {
+	r    *bufio.Reader
+	line int
+}
+
+type dumpHeader struct {
+	print   bool
+	name    *string
+	flags   uint
+	mapsize uint64
+}
+
+// readLine returns the next line without its newline. io.EOF is only
+// returned if there is no more input.
+func (l *loader) readLine() ([]byte, error) {
+	line, err := l.r.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.line++
+	return bytes.TrimSuffix(line, []byte("\n")), nil
+}
+
+func (l *loader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+func (l *loader) header() (*dumpHeader, error) {
+	h := &dumpHeader{}
+	first := true
+	for {
+		line, err := l.readLine()
+		if err == io.EOF && !first {
+			return nil, errors.New("Unexpected end of header")
+		}
+		if err != nil {
+			return nil, err
+		}
+		first = false
+		kv := bytes.SplitN(line, []byte("="), 2)
+		if len(kv) != 2 {
+			return nil, l.errorf("unexpected line in header")
+		}
+		key, val := string(kv[0]), string(kv[1])
+		switch key {
+		case "HEADER":
+			if val != "END" {
+				return nil, l.errorf("unexpected line in header")
+			}
+			return h, nil
+		case "VERSION":
+			if val != "3" {
+				return nil, l.errorf("unsupported VERSION %s", val)
+			}
+		case "format":
+			switch val {
+			case "print":
+				h.print = true
+			case "bytevalue":
+				h.print = false
+			default:
+				return nil, l.errorf("unsupported format %s", val)
+			}
+		case "type":
+			if val != "btree" {
+				return nil, l.errorf("unsupported type %s", val)
+			}
+		case "database":
+			h.name = &val
+		case "mapsize":
+			h.mapsize, err = strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return nil, l.errorf("invalid mapsize %s", val)
+			}
+		case "mapaddr", "maxreaders", "db_pagesize":
+		case "duplicates":
+			if val == "1" {
+				h.flags |= DUPSORT
+			}
+		default:
+			found := false
+			for _, f := range dumpFlags {
+				if key == f.name {
+					if val == "1" {
+						h.flags |= f.flag
+					}
+					found = true
+				}
+			}
+			if !found {
+				return nil, l.errorf("unexpected line in header")
+			}
+		}
+	}
+}
+
+// load stores the records following h up to DATA=END.
+func (l *loader) load(env *Env, h *dumpHeader) error {
+	info, err := env.Info()
+	if err != nil {
+		return err
+	}
+	if h.mapsize > info.MapSize {
+		err = env.SetMapSize(h.mapsize)
+		if err != nil {
+			return err
+		}
+	}
+	var dbi DBI
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.DBIOpen(h.name, h.flags|CREATE)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for done := false; !done; {
+		var batch []KV
+		for len(batch) < loadBatchSize {
+			key, err := l.record(h.print, true)
+			if err != nil {
+				return err
+			}
+			if key == nil {
+				done = true
+				break
+			}
+			val, err := l.record(h.print, false)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, KV{key, val})
+		}
+		err = env.Update(func(txn *Txn) error {
+			for _, kv := range batch {
+				err := txn.Put(dbi, kv.Key, kv.Val, 0)
+				if err != nil && err != KeyExist {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record reads a key or a value. It returns nil at the end of the records
+// if isKey is true.
+func (l *loader) record(print, isKey bool) ([]byte, error) {
+	line, err := l.readLine()
+	if err == io.EOF {
+		return nil, errors.New("Unexpected end of input")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isKey && string(line) == "DATA=END" {
+		return nil, nil
+	}
+	if len(line) == 0 || line[0] != ' ' {
+		return nil, l.errorf("unexpected line in data")
+	}
+	line = line[1:]
+	if !print {
+		p := make([]byte, hex.DecodedLen(len(line)))
+		_, err = hex.Decode(p, line)
+		if err != nil {
+			return nil, l.errorf("invalid hexadecimal data")
+		}
+		return p, nil
+	}
+	p := make([]byte, 0, len(line))
+	for i := 0; i < len(line); i++ {
+		if line[i] != '\\' {
+			p = append(p, line[i])
+			continue
+		}
+		if i+1 < len(line) && line[i+1] == '\\' {
+			p = append(p, '\\')
+			i++
+			continue
+		}
+		if i+2 >= len(line) {
+			return nil, l.errorf("invalid escape sequence")
+		}
+		var c [1]byte
+		_, err = hex.Decode(c[:], line[i+1:i+3])
+		if err != nil {
+			return nil, l.errorf("invalid escape sequence")
+		}
+		p = append(p, c[0])
+		i += 2
+	}
+	return p, nil
+}
@@ -0,0 +1,131 @@
+package mdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDumpLoad(t *testing.T) {
+	env := setupMaxDBs(t, 2)
+	defer clean(env, t)
+
+	name := "dups"
+	err := env.Update(func(txn *Txn) error {
+		main, err := txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		err = txn.Put(main, []byte("a\\b"), []byte{0, 0xff, 'x'}, 0)
+		if err != nil {
+			return err
+		}
+		dbi, err := txn.DBIOpen(&name, CREATE|DUPSORT)
+		if err != nil {
+			return err
+		}
+		for _, v := range []string{"1", "2", "0"} {
+			err = txn.Put(dbi, []byte("k"), []byte(v), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot fill databases: %s", err)
+	}
+	info, err := env.Info()
+	if err != nil {
+		t.Fatalf("Cannot get info: %s", err)
+	}
+	header := fmt.Sprintf("type=btree\nmapsize=%d\nmaxreaders=%d\n", info.MapSize, info.MaxReaders)
+	pagesize := fmt.Sprintf("db_pagesize=%d\nHEADER=END\n", os.Getpagesize())
+
+	tests := []struct {
+		opts     DumpOptions
+		expected string
+	}{
+		{DumpOptions{Print: true}, "VERSION=3\nformat=print\n" + header + pagesize +
+			" a\\\\b\n \\00\\ffx\nDATA=END\n" +
+			"VERSION=3\nformat=print\ndatabase=dups\n" + header + "duplicates=1\ndupsort=1\n" + pagesize +
+			" k\n 0\n k\n 1\n k\n 2\nDATA=END\n"},
+		{DumpOptions{}, "VERSION=3\nformat=bytevalue\n" + header + pagesize +
+			" 615c62\n 00ff78\nDATA=END\n" +
+			"VERSION=3\nformat=bytevalue\ndatabase=dups\n" + header + "duplicates=1\ndupsort=1\n" + pagesize +
+			" 6b\n 30\n 6b\n 31\n 6b\n 32\nDATA=END\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err = env.View(func(txn *Txn) error {
+			return DumpAll(txn, &buf, test.opts)
+		})
+		if err != nil {
+			t.Fatalf("Cannot dump: %s", err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("Unexpected dump:\n%s\nexpected:\n%s", buf.String(), test.expected)
+		}
+
+		lenv := setupMaxDBs(t, 2)
+		err = Load(lenv, &buf)
+		if err != nil {
+			t.Fatalf("Cannot load: %s", err)
+		}
+		err = lenv.View(func(txn *Txn) error {
+			main, err := txn.DBIOpen(nil, 0)
+			if err != nil {
+				return err
+			}
+			val, err := txn.Get(main, []byte("a\\b"))
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(val, []byte{0, 0xff, 'x'}) {
+				t.Errorf("Unexpected loaded value: %q", val)
+			}
+			dbi, err := txn.DBIOpen(&name, 0)
+			if err != nil {
+				return err
+			}
+			flags, err := txn.Flags(dbi)
+			if err != nil {
+				return err
+			}
+			if flags != DUPSORT {
+				t.Errorf("Unexpected flags of the loaded database: %x", flags)
+			}
+			_, vals := scanKeyVals(t, txn, dbi)
+			if len(vals) != 3 || string(vals[1]) != "1" || string(vals[2]) != "2" {
+				t.Errorf("Unexpected loaded duplicates: %q", vals)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("View: %s", err)
+		}
+		clean(lenv, t)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	tests := []string{
+		"VERSION=2\nHEADER=END\n",
+		"VERSION=3\nformat=bytevalue\nfoo=1\nHEADER=END\n",
+		"VERSION=3\nformat=bytevalue\nHEADER=END\n 6g\n 00\nDATA=END\n",
+		"VERSION=3\nformat=print\nHEADER=END\n a\\0\n",
+		"VERSION=3\nformat=print\nHEADER=END\n a\n",
+		"VERSION=3\nformat=print\n",
+	}
+	for _, test := range tests {
+		err := Load(env, strings.NewReader(test))
+		if err == nil {
+			t.Errorf("Loaded invalid input %q", test)
+		}
+	}
+}
@@ -7,13 +7,45 @@ package mdb
 #cgo netbsd CFLAGS: -DMDB_DSYNC=O_SYNC
 #include <stdlib.h>
 #include <stdio.h>
+#include <string.h>
 #include "lmdb.h"
+
+typedef struct gomdb_msgbuf {
+	char *buf;
+	size_t len, cap;
+} gomdb_msgbuf;
+
+static int gomdb_msg_append(const char *msg, void *ctx) {
+	gomdb_msgbuf *mb = ctx;
+	size_t n = strlen(msg);
+	if (mb->len + n > mb->cap) {
+		size_t cap = 2 * mb->cap + n;
+		char *buf = realloc(mb->buf, cap);
+		if (buf == NULL)
+			return -1;
+		mb->buf = buf;
+		mb->cap = cap;
+	}
+	memcpy(mb->buf + mb->len, msg, n);
+	mb->len += n;
+	return 0;
+}
+
+static int gomdb_reader_list(MDB_env *env, gomdb_msgbuf *mb) {
+	return mdb_reader_list(env, gomdb_msg_append, mb);
+}
 */
 import "C"
 
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"unsafe"
 )
@@ -93,7 +125,21 @@ func Version() string {
 // A DB environment supports multiple databases, all residing in the
 // same shared-memory map.
 type Env struct {
-	_env *C.MDB_env
+	_env   *C.MDB_env
+	writer chan struct{} // held by the write transaction of this process
+
+	mu        sync.Mutex // protects the fields below
+	growth    *GrowthPolicy
+	closing   bool
+	batchOpts BatchOptions
+	batcher   *batcher
+	sweepers  map[*ExpiringBucket]*sweeper
+	changelog *changelog
+	readers   int // read-only transactions of the process with a snapshot
+
+	internalMu   sync.RWMutex
+	internal     map[string]DBI // handles of the internal databases
+	internalOpen bool           // the existing internal databases are open
 }
 
 // Create an MDB environment handle.
@@ -103,7 +149,7 @@ func NewEnv() (*Env, error) {
 	if ret != SUCCESS {
 		return nil, errno(ret)
 	}
-	return &Env{_env}, nil
+	return &Env{_env: _env, writer: make(chan struct{}, 1)}, nil
 }
 
 // Open an environment handle. If this function fails Close() must be called to discard the Env handle.
@@ -118,7 +164,14 @@ func (env *Env) Close() error {
 	if env._env == nil {
 		return errors.New("Environment already closed")
 	}
+	env.stopBatcher()
+	env.stopSweepers()
+	if log := env.getChangelog(); log != nil {
+		log.close()
+	}
 	C.mdb_env_close(env._env)
+	releaseCmps(env._env, 0, true)
+	releaseDBINames(env._env, 0, true)
 	env._env = nil
 	return nil
 }
@@ -130,6 +183,64 @@ func (env *Env) Copy(path string) error {
 	return errno(ret)
 }
 
+// mdb_env_copy2 flags
+const (
+	CP_COMPACT = C.MDB_CP_COMPACT // omit free space from the copy and renumber all pages sequentially
+)
+
+// CopyOptions controls how CopyWithOptions copies the environment.
+type CopyOptions struct {
+	Compact bool // Omit free pages from the copy. This is slower than a plain copy.
+}
+
+// Copy the environment to the directory path, which must already exist and
+// be empty.
+func (env *Env) CopyWithOptions(path string, opts CopyOptions) error {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	ret := C.mdb_env_copy2(env._env, cpath, C.uint(opts.flags()))
+	return errno(ret)
+}
+
+// CopyTo writes a consistent snapshot of the environment to w. The snapshot
+// has the format of the data file and can be opened as an environment once
+// it is saved as data.mdb. If compact is true free pages are omitted.
+//
+// The copy is written through a pipe so w is not required to be a file. If w
+// fails the rest of the snapshot is discarded and the error of w is returned.
+func (env *Env) CopyTo(w io.Writer, compact bool) error {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
+	flags := CopyOptions{Compact: compact}.flags()
+	done := make(chan error, 1)
+	go func() {
+		ret := C.mdb_env_copyfd2(env._env, C.mdb_filehandle_t(pw.Fd()), C.uint(flags))
+		pw.Close()
+		done <- errno(ret)
+	}()
+	_, werr := io.Copy(w, pr)
+	if werr != nil {
+		// Drain the pipe so the copy never blocks or writes to a closed pipe.
+		io.Copy(ioutil.Discard, pr)
+	}
+	err = <-done
+	if err != nil {
+		return err
+	}
+	return werr
+}
+
+func (opts CopyOptions) flags() uint {
+	var flags uint
+	if opts.Compact {
+		flags |= CP_COMPACT
+	}
+	return flags
+}
+
 // Statistics for a database in the environment
 type Stat struct {
 	PSize         uint   // Size of a database page. This is currently the same for all databases.
@@ -177,6 +288,67 @@ func (env *Env) Info() (*Info, error) {
 	return &info, nil
 }
 
+// Check for stale entries in the reader lock table and clear them. The
+// number of cleared entries is returned.
+func (env *Env) ReaderCheck() (int, error) {
+	var _dead C.int
+	ret := C.mdb_reader_check(env._env, &_dead)
+	if ret != SUCCESS {
+		return 0, errno(ret)
+	}
+	return int(_dead), nil
+}
+
+// An entry of the reader lock table.
+type ReaderInfo struct {
+	PID    int    // process ID of the reader
+	Thread uint64 // thread ID of the reader
+	TxnID  uint64 // ID of the snapshot being read
+	Active bool   // false if the slot is reserved but no snapshot is read
+}
+
+// Readers returns the entries of the reader lock table.
+func (env *Env) Readers() ([]ReaderInfo, error) {
+	var mb C.gomdb_msgbuf
+	ret := C.gomdb_reader_list(env._env, &mb)
+	defer C.free(unsafe.Pointer(mb.buf))
+	if ret < 0 {
+		return nil, syscall.ENOMEM
+	}
+	return parseReaderList(C.GoStringN(mb.buf, C.int(mb.len)))
+}
+
+// parseReaderList parses the table produced by mdb_reader_list.
+func parseReaderList(list string) ([]ReaderInfo, error) {
+	var readers []ReaderInfo
+	for _, line := range strings.Split(list, "\n") {
+		fields := strings.Fields(line)
+		// Skip the header and messages like "(no active readers)".
+		if len(fields) != 3 || fields[0] == "pid" || strings.HasPrefix(line, "(") {
+			continue
+		}
+		var r ReaderInfo
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid reader entry %q: %s", line, err)
+		}
+		r.PID = pid
+		r.Thread, err = strconv.ParseUint(fields[1], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid reader entry %q: %s", line, err)
+		}
+		if fields[2] != "-" {
+			r.TxnID, err = strconv.ParseUint(fields[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid reader entry %q: %s", line, err)
+			}
+			r.Active = true
+		}
+		readers = append(readers, r)
+	}
+	return readers, nil
+}
+
 func (env *Env) Sync(force int) error {
 	ret := C.mdb_env_sync(env._env, C.int(force))
 	return errno(ret)
@@ -212,6 +384,98 @@ func (env *Env) SetMapSize(size uint64) error {
 	return errno(ret)
 }
 
+// GrowthPolicy describes how Env.Update enlarges the memory map when a
+// transaction fails with MapFull.
+type GrowthPolicy struct {
+	Step    uint64 // Bytes added per growth. If zero the map size is doubled.
+	MaxSize uint64 // Hard cap on the map size. If zero the map grows without limit.
+}
+
+// SetGrowthPolicy enables automatic map growth for transactions run by
+// Env.Update. A nil policy disables growth. LMDB can only resize the map
+// while no transaction of the process is active. Update holds the write
+// lock of the Env until its transaction commits, so no other writer of the
+// process begins meanwhile, but if read-only transactions are active the
+// map is not grown and MapFull is returned.
+func (env *Env) SetGrowthPolicy(policy *GrowthPolicy) {
+	if policy != nil {
+		p := *policy
+		policy = &p
+	}
+	env.mu.Lock()
+	env.growth = policy
+	env.mu.Unlock()
+}
+
+// growthPolicy returns the growth policy, or nil if growth is disabled.
+func (env *Env) growthPolicy() *GrowthPolicy {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	return env.growth
+}
+
+// grow enlarges the memory map according to policy. MapFull is returned if
+// the map is already at its maximum size.
+func (env *Env) grow(policy *GrowthPolicy) error {
+	info, err := env.Info()
+	if err != nil {
+		return err
+	}
+	size := info.MapSize * 2
+	if policy.Step > 0 {
+		size = info.MapSize + policy.Step
+	}
+	if policy.MaxSize > 0 && size > policy.MaxSize {
+		size = policy.MaxSize
+	}
+	if size <= info.MapSize {
+		return MapFull
+	}
+	resized, err := env.resizeIdle(size)
+	if err == nil && !resized {
+		err = MapFull
+	}
+	return err
+}
+
+// adoptMapSize adopts the map size set by another process after a
+// transaction failed to begin with MapResized, and reports whether it did.
+// The write lock of the Env is taken unless locked is set; the size is not
+// adopted if another goroutine holds it or read-only transactions are
+// active.
+func (env *Env) adoptMapSize(locked bool) bool {
+	if !locked {
+		select {
+		case env.writer <- struct{}{}:
+			defer func() { <-env.writer }()
+		default:
+			return false
+		}
+	}
+	resized, err := env.resizeIdle(0)
+	return resized && err == nil
+}
+
+// resizeIdle sets the map size like SetMapSize if no read-only transaction
+// of the process is active, and reports whether it did. The write lock of
+// the Env must be held.
+func (env *Env) resizeIdle(size uint64) (bool, error) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.readers > 0 {
+		return false, nil
+	}
+	return true, env.SetMapSize(size)
+}
+
+// addReaders adds n to the number of active read-only transactions. They
+// are counted before they begin, so that the map is not resized meanwhile.
+func (env *Env) addReaders(n int) {
+	env.mu.Lock()
+	env.readers += n
+	env.mu.Unlock()
+}
+
 func (env *Env) SetMaxReaders(size uint) error {
 	ret := C.mdb_env_set_maxreaders(env._env, C.uint(size))
 	return errno(ret)
@@ -222,6 +486,14 @@ func (env *Env) SetMaxDBs(size DBI) error {
 	return errno(ret)
 }
 
+// MaxKeySize returns the maximum size of keys and of DUPSORT data items.
+func (env *Env) MaxKeySize() int {
+	return int(C.mdb_env_get_maxkeysize(env._env))
+}
+
 func (env *Env) DBIClose(dbi DBI) {
 	C.mdb_dbi_close(env._env, C.MDB_dbi(dbi))
+	env.forgetInternalDBI(dbi)
+	releaseCmps(env._env, dbi, false)
+	releaseDBINames(env._env, dbi, false)
 }
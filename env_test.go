@@ -1,8 +1,12 @@
 package mdb
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -36,10 +40,21 @@ func TestEnvOpen(t *testing.T) {
 }
 
 func setup(t *testing.T) *Env {
+	return setupMaxDBs(t, 0)
+}
+
+// setupMaxDBs is like setup but allows maxdbs named databases.
+func setupMaxDBs(t *testing.T, maxdbs DBI) *Env {
 	env, err := NewEnv()
 	if err != nil {
 		t.Errorf("Cannot create enviroment: %s", err)
 	}
+	if maxdbs > 0 {
+		err = env.SetMaxDBs(maxdbs)
+		if err != nil {
+			t.Errorf("Cannot set maxdbs: %s", err)
+		}
+	}
 	path, err := ioutil.TempDir("/tmp", "mdb_test")
 	if err != nil {
 		t.Errorf("Cannot create temporary directory")
@@ -77,3 +92,258 @@ func TestEnvCopy(t *testing.T) {
 	env := setup(t)
 	clean(env, t)
 }
+
+func TestEnvCopyCompact(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	err := env.SetMapSize(1 << 24)
+	if err != nil {
+		t.Fatalf("Cannot set mapsize: %s", err)
+	}
+	var dbi DBI
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2000; i++ {
+			key := fmt.Sprintf("Key-%04d", i)
+			err = txn.Put(dbi, []byte(key), make([]byte, 256), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot fill database: %s", err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		for i := 0; i < 2000; i += 2 {
+			key := fmt.Sprintf("Key-%04d", i)
+			err := txn.Del(dbi, []byte(key), nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot delete entries: %s", err)
+	}
+
+	plain, err := ioutil.TempDir("/tmp", "mdb_test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory")
+	}
+	defer os.RemoveAll(plain)
+	compact, err := ioutil.TempDir("/tmp", "mdb_test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory")
+	}
+	defer os.RemoveAll(compact)
+
+	err = env.CopyWithOptions(plain, CopyOptions{})
+	if err != nil {
+		t.Fatalf("Cannot copy environment: %s", err)
+	}
+	err = env.CopyWithOptions(compact, CopyOptions{Compact: true})
+	if err != nil {
+		t.Fatalf("Cannot copy environment with compaction: %s", err)
+	}
+	plainInfo, err := os.Stat(filepath.Join(plain, "data.mdb"))
+	if err != nil {
+		t.Fatalf("Cannot stat copy: %s", err)
+	}
+	compactInfo, err := os.Stat(filepath.Join(compact, "data.mdb"))
+	if err != nil {
+		t.Fatalf("Cannot stat compacted copy: %s", err)
+	}
+	if compactInfo.Size() >= plainInfo.Size() {
+		t.Errorf("Compacted copy is not smaller: %d >= %d", compactInfo.Size(), plainInfo.Size())
+	}
+
+	cenv, err := NewEnv()
+	if err != nil {
+		t.Fatalf("Cannot create environment: %s", err)
+	}
+	err = cenv.Open(compact, 0, 0664)
+	if err != nil {
+		t.Fatalf("Cannot open compacted copy: %s", err)
+	}
+	defer cenv.Close()
+	err = cenv.View(func(ctxn *Txn) error {
+		cdbi, err := ctxn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		return env.View(func(txn *Txn) error {
+			for i := 0; i < 2000; i++ {
+				key := []byte(fmt.Sprintf("Key-%04d", i))
+				val, err := txn.Get(dbi, key)
+				cval, cerr := ctxn.Get(cdbi, key)
+				if err != cerr || !bytes.Equal(val, cval) {
+					t.Errorf("Copy differs at %s: %v %v", key, err, cerr)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("Cannot read compacted copy: %s", err)
+	}
+}
+
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n < len(p) {
+		return 0, errors.New("writer failed")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestEnvCopyTo(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("Key-%d", i)
+			val := fmt.Sprintf("Val-%d", i)
+			err = txn.Put(dbi, []byte(key), []byte(val), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot fill database: %s", err)
+	}
+
+	for _, compact := range []bool{false, true} {
+		var buf bytes.Buffer
+		err = env.CopyTo(&buf, compact)
+		if err != nil {
+			t.Fatalf("Cannot copy environment (compact=%v): %s", compact, err)
+		}
+		path, err := ioutil.TempDir("/tmp", "mdb_test")
+		if err != nil {
+			t.Fatalf("Cannot create temporary directory")
+		}
+		defer os.RemoveAll(path)
+		err = ioutil.WriteFile(filepath.Join(path, "data.mdb"), buf.Bytes(), 0664)
+		if err != nil {
+			t.Fatalf("Cannot write snapshot: %s", err)
+		}
+		cenv, err := NewEnv()
+		if err != nil {
+			t.Fatalf("Cannot create environment: %s", err)
+		}
+		err = cenv.Open(path, 0, 0664)
+		if err != nil {
+			t.Fatalf("Cannot open snapshot: %s", err)
+		}
+		err = cenv.View(func(txn *Txn) error {
+			dbi, err := txn.DBIOpen(nil, 0)
+			if err != nil {
+				return err
+			}
+			val, err := txn.Get(dbi, []byte("Key-42"))
+			if err != nil {
+				return err
+			}
+			if string(val) != "Val-42" {
+				t.Errorf("Unexpected value in snapshot: %q", val)
+			}
+			return nil
+		})
+		cenv.Close()
+		if err != nil {
+			t.Fatalf("Cannot read snapshot: %s", err)
+		}
+	}
+
+	err = env.CopyTo(&failingWriter{n: 4096}, false)
+	if err == nil || err.Error() != "writer failed" {
+		t.Errorf("Expected the writer error: %v", err)
+	}
+}
+
+func TestEnvReaders(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	readers, err := env.Readers()
+	if err != nil {
+		t.Fatalf("Cannot list readers: %s", err)
+	}
+	if len(readers) != 0 {
+		t.Errorf("Unexpected readers: %+v", readers)
+	}
+
+	txn, err := env.BeginTxn(nil, RDONLY)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction: %s", err)
+	}
+	readers, err = env.Readers()
+	if err != nil {
+		t.Fatalf("Cannot list readers: %s", err)
+	}
+	if len(readers) != 1 || readers[0].PID != os.Getpid() || !readers[0].Active {
+		t.Errorf("Unexpected readers: %+v", readers)
+	}
+	txn.Reset()
+	readers, err = env.Readers()
+	if err != nil {
+		t.Fatalf("Cannot list readers: %s", err)
+	}
+	if len(readers) != 1 || readers[0].Active {
+		t.Errorf("Unexpected readers after reset: %+v", readers)
+	}
+	txn.Abort()
+
+	dead, err := env.ReaderCheck()
+	if err != nil {
+		t.Fatalf("Cannot check readers: %s", err)
+	}
+	if dead != 0 {
+		t.Errorf("Unexpected stale readers: %d", dead)
+	}
+}
+
+func TestParseReaderList(t *testing.T) {
+	list := "    pid     thread     txnid\n" +
+		"      1234 7f0a2c1e5700 42\n" +
+		"      5678 7f0a2c1e6700 -\n"
+	readers, err := parseReaderList(list)
+	if err != nil {
+		t.Fatalf("Cannot parse reader list: %s", err)
+	}
+	expected := []ReaderInfo{
+		{PID: 1234, Thread: 0x7f0a2c1e5700, TxnID: 42, Active: true},
+		{PID: 5678, Thread: 0x7f0a2c1e6700},
+	}
+	if len(readers) != len(expected) {
+		t.Fatalf("Unexpected readers: %+v", readers)
+	}
+	for i := range expected {
+		if readers[i] != expected[i] {
+			t.Errorf("Reader %d is %+v, expected %+v", i, readers[i], expected[i])
+		}
+	}
+	readers, err = parseReaderList("(no active readers)\n")
+	if err != nil || len(readers) != 0 {
+		t.Errorf("Unexpected result for empty table: %+v %v", readers, err)
+	}
+}
@@ -0,0 +1,193 @@
+package mdb
+
+import (
+	"errors"
+)
+
+// IndexFunc returns the index keys of an entry of a primary database. It may
+// return no keys, in which case the entry is not indexed.
+type IndexFunc func(key, val []byte) [][]byte
+
+// Indexed is a primary database with secondary indexes. Every index is a
+// DUPSORT database named "<primary>.<index>" mapping each index key to the
+// keys of the primary entries it was extracted from. Writes through Put and
+// Del update the indexes in the same transaction; writes made directly to
+// the primary DBI are not indexed and require Rebuild.
+//
+// Index keys and primary keys must not be longer than the maximum key size
+// of the environment.
+type Indexed struct {
+	name    string
+	dbi     DBI
+	indexes map[string]*index
+}
+
+type index struct {
+	dbi DBI
+	fn  IndexFunc
+}
+
+// OpenIndexed opens the named database name as the primary database of an
+// Indexed. flags are passed to DBIOpen; DUPSORT is not allowed.
+func OpenIndexed(txn *Txn, name string, flags uint) (*Indexed, error) {
+	if flags&DUPSORT != 0 {
+		return nil, errors.New("Primary database must not be DUPSORT")
+	}
+	dbi, err := txn.DBIOpen(&name, flags)
+	if err != nil {
+		return nil, err
+	}
+	return &Indexed{name: name, dbi: dbi, indexes: map[string]*index{}}, nil
+}
+
+// AddIndex opens or creates the index called name whose keys are extracted
+// by fn. Entries stored before the index was added are only indexed after
+// Rebuild.
+func (x *Indexed) AddIndex(txn *Txn, name string, fn IndexFunc) error {
+	dbname := x.name + "." + name
+	dbi, err := txn.DBIOpen(&dbname, CREATE|DUPSORT)
+	if err != nil {
+		return err
+	}
+	x.indexes[name] = &index{dbi, fn}
+	return nil
+}
+
+// DBI returns the handle of the primary database.
+func (x *Indexed) DBI() DBI {
+	return x.dbi
+}
+
+// IndexDBI returns the handle of the database of the index called name.
+func (x *Indexed) IndexDBI(name string) (DBI, bool) {
+	idx, ok := x.indexes[name]
+	if !ok {
+		return 0, false
+	}
+	return idx.dbi, true
+}
+
+// Get returns the value of key in the primary database.
+func (x *Indexed) Get(txn *Txn, key []byte) ([]byte, error) {
+	return txn.Get(x.dbi, key)
+}
+
+// Put stores val for key in the primary database and updates all indexes.
+// flags are passed to Txn.Put for the primary database.
+func (x *Indexed) Put(txn *Txn, key, val []byte, flags uint) error {
+	old, err := txn.Get(x.dbi, key)
+	if err != nil && err != NotFound {
+		return err
+	}
+	exists := err == nil
+	err = txn.Put(x.dbi, key, val, flags)
+	if err != nil {
+		return err
+	}
+	for _, idx := range x.indexes {
+		if exists {
+			err = idx.remove(txn, key, old)
+			if err != nil {
+				return err
+			}
+		}
+		err = idx.add(txn, key, val)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Del removes key from the primary database and all indexes.
+func (x *Indexed) Del(txn *Txn, key []byte) error {
+	old, err := txn.Get(x.dbi, key)
+	if err != nil {
+		return err
+	}
+	err = txn.Del(x.dbi, key, nil)
+	if err != nil {
+		return err
+	}
+	for _, idx := range x.indexes {
+		err = idx.remove(txn, key, old)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup returns the keys of the primary entries with the index key ikey in
+// the index called name, in primary key order.
+func (x *Indexed) Lookup(txn *Txn, name string, ikey []byte) ([][]byte, error) {
+	idx, ok := x.indexes[name]
+	if !ok {
+		return nil, errors.New("Unknown index " + name)
+	}
+	cursor, err := txn.CursorOpen(idx.dbi)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var keys [][]byte
+	_, key, err := cursor.Get(ikey, nil, SET_KEY)
+	for err == nil {
+		keys = append(keys, key)
+		_, key, err = cursor.Get(nil, nil, NEXT_DUP)
+	}
+	if err != NotFound {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Rebuild empties the index called name and indexes all entries of the
+// primary database again.
+func (x *Indexed) Rebuild(txn *Txn, name string) error {
+	idx, ok := x.indexes[name]
+	if !ok {
+		return errors.New("Unknown index " + name)
+	}
+	err := txn.Drop(idx.dbi, 0)
+	if err != nil {
+		return err
+	}
+	cursor, err := txn.CursorOpen(x.dbi)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	key, val, err := cursor.GetVal(nil, nil, FIRST)
+	for err == nil {
+		err = idx.add(txn, key.BytesNoCopy(), val.BytesNoCopy())
+		if err != nil {
+			return err
+		}
+		key, val, err = cursor.GetVal(nil, nil, NEXT)
+	}
+	if err != NotFound {
+		return err
+	}
+	return nil
+}
+
+func (idx *index) add(txn *Txn, key, val []byte) error {
+	for _, ikey := range idx.fn(key, val) {
+		err := txn.Put(idx.dbi, ikey, key, NODUPDATA)
+		if err != nil && err != KeyExist {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *index) remove(txn *Txn, key, val []byte) error {
+	for _, ikey := range idx.fn(key, val) {
+		err := txn.Del(idx.dbi, ikey, key)
+		if err != nil && err != NotFound {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package mdb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// byCity indexes values of the form "name|city" by city.
+func byCity(key, val []byte) [][]byte {
+	i := bytes.IndexByte(val, '|')
+	if i < 0 {
+		return nil
+	}
+	return [][]byte{val[i+1:]}
+}
+
+func lookupString(t *testing.T, txn *Txn, x *Indexed, name, ikey string) string {
+	keys, err := x.Lookup(txn, name, []byte(ikey))
+	if err != nil {
+		t.Fatalf("Cannot lookup %s: %s", ikey, err)
+	}
+	var s []string
+	for _, key := range keys {
+		s = append(s, string(key))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestIndexed(t *testing.T) {
+	env := setupMaxDBs(t, 2)
+	defer clean(env, t)
+
+	err := env.Update(func(txn *Txn) error {
+		x, err := OpenIndexed(txn, "people", CREATE)
+		if err != nil {
+			return err
+		}
+		err = x.AddIndex(txn, "city", byCity)
+		if err != nil {
+			return err
+		}
+		for _, kv := range [][2]string{{"1", "ann|oslo"}, {"2", "bob|rome"}, {"3", "cid|oslo"}, {"4", "dan"}} {
+			err = x.Put(txn, []byte(kv[0]), []byte(kv[1]), 0)
+			if err != nil {
+				return err
+			}
+		}
+		if keys := lookupString(t, txn, x, "city", "oslo"); keys != "1 3" {
+			t.Errorf("oslo: %q", keys)
+		}
+
+		err = x.Put(txn, []byte("1"), []byte("ann|rome"), 0)
+		if err != nil {
+			return err
+		}
+		err = x.Put(txn, []byte("2"), []byte("bob|oslo"), NOOVERWRITE)
+		if err != KeyExist {
+			t.Errorf("Put with NOOVERWRITE: %v", err)
+		}
+		err = x.Del(txn, []byte("2"))
+		if err != nil {
+			return err
+		}
+		if keys := lookupString(t, txn, x, "city", "oslo"); keys != "3" {
+			t.Errorf("oslo after update: %q", keys)
+		}
+		if keys := lookupString(t, txn, x, "city", "rome"); keys != "1" {
+			t.Errorf("rome after update: %q", keys)
+		}
+		if _, err = x.Lookup(txn, "name", nil); err == nil {
+			t.Errorf("Lookup in unknown index succeeded")
+		}
+
+		// Writes bypassing the wrapper are picked up by Rebuild.
+		err = txn.Put(x.DBI(), []byte("5"), []byte("eve|oslo"), 0)
+		if err != nil {
+			return err
+		}
+		err = x.Rebuild(txn, "city")
+		if err != nil {
+			return err
+		}
+		if keys := lookupString(t, txn, x, "city", "oslo"); keys != "3 5" {
+			t.Errorf("oslo after rebuild: %q", keys)
+		}
+		dbi, _ := x.IndexDBI("city")
+		stat, err := txn.Stat(dbi)
+		if err != nil {
+			return err
+		}
+		if stat.Entries != 3 {
+			t.Errorf("Unexpected number of index entries: %d", stat.Entries)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+}
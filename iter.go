@@ -0,0 +1,145 @@
+//go:build go1.23
+
+package mdb
+
+import (
+	"iter"
+)
+
+// Range-over-func iterators. The sequences yield copies of the keys and
+// values. Each sequence is returned with a function that reports the error
+// that stopped its last iteration, or nil. The cursors opened by the
+// sequences of a Txn are closed when the loop ends, including when it is
+// left early:
+//
+//	entries, iterErr := txn.All(dbi)
+//	for k, v := range entries {
+//		fmt.Printf("%s: %s\n", k, v)
+//	}
+//	if err := iterErr(); err != nil {
+//		...
+//	}
+
+// All returns a sequence over all entries of dbi in ascending key order.
+func (txn *Txn) All(dbi DBI) (iter.Seq2[[]byte, []byte], func() error) {
+	return txn.seq(dbi, nil)
+}
+
+// Backward returns a sequence over all entries of dbi in descending key order.
+func (txn *Txn) Backward(dbi DBI) (iter.Seq2[[]byte, []byte], func() error) {
+	return txn.seq(dbi, &IterOptions{Reverse: true})
+}
+
+// Range returns a sequence over the entries of dbi with keys in [from, to).
+// A nil bound is unbounded.
+func (txn *Txn) Range(dbi DBI, from, to []byte) (iter.Seq2[[]byte, []byte], func() error) {
+	return txn.seq(dbi, &IterOptions{Lower: from, Upper: to})
+}
+
+// Prefix returns a sequence over the entries of dbi whose keys start with p.
+func (txn *Txn) Prefix(dbi DBI, p []byte) (iter.Seq2[[]byte, []byte], func() error) {
+	return txn.seq(dbi, &IterOptions{Prefix: p})
+}
+
+// Dups returns a sequence over the duplicate data items of key in a DUPSORT
+// database.
+func (txn *Txn) Dups(dbi DBI, key []byte) (iter.Seq2[[]byte, []byte], func() error) {
+	var iterErr error
+	seq := func(yield func(k, v []byte) bool) {
+		iterErr = nil
+		cursor, err := txn.CursorOpen(dbi)
+		if err != nil {
+			iterErr = err
+			return
+		}
+		defer cursor.Close()
+		iterErr = dups(cursor, key, yield)
+	}
+	return seq, func() error { return iterErr }
+}
+
+func (txn *Txn) seq(dbi DBI, opts *IterOptions) (iter.Seq2[[]byte, []byte], func() error) {
+	var iterErr error
+	seq := func(yield func(k, v []byte) bool) {
+		iterErr = nil
+		it, err := txn.Iterator(dbi, opts)
+		if err != nil {
+			iterErr = err
+			return
+		}
+		defer it.Close()
+		iterErr = each(it, yield)
+	}
+	return seq, func() error { return iterErr }
+}
+
+// All returns a sequence over all entries of the cursor's database in
+// ascending key order. The cursor is left open.
+func (cursor *Cursor) All() (iter.Seq2[[]byte, []byte], func() error) {
+	return cursor.seq(nil)
+}
+
+// Backward returns a sequence over all entries of the cursor's database in
+// descending key order.
+func (cursor *Cursor) Backward() (iter.Seq2[[]byte, []byte], func() error) {
+	return cursor.seq(&IterOptions{Reverse: true})
+}
+
+// Range returns a sequence over the entries with keys in [from, to). A nil
+// bound is unbounded.
+func (cursor *Cursor) Range(from, to []byte) (iter.Seq2[[]byte, []byte], func() error) {
+	return cursor.seq(&IterOptions{Lower: from, Upper: to})
+}
+
+// Prefix returns a sequence over the entries whose keys start with p.
+func (cursor *Cursor) Prefix(p []byte) (iter.Seq2[[]byte, []byte], func() error) {
+	return cursor.seq(&IterOptions{Prefix: p})
+}
+
+// Dups returns a sequence over the duplicate data items of key in a DUPSORT
+// database.
+func (cursor *Cursor) Dups(key []byte) (iter.Seq2[[]byte, []byte], func() error) {
+	var iterErr error
+	seq := func(yield func(k, v []byte) bool) {
+		iterErr = dups(cursor, key, yield)
+	}
+	return seq, func() error { return iterErr }
+}
+
+func (cursor *Cursor) seq(opts *IterOptions) (iter.Seq2[[]byte, []byte], func() error) {
+	var iterErr error
+	seq := func(yield func(k, v []byte) bool) {
+		iterErr = nil
+		it, err := newIterator(cursor, opts, false)
+		if err != nil {
+			iterErr = err
+			return
+		}
+		defer it.Close()
+		iterErr = each(it, yield)
+	}
+	return seq, func() error { return iterErr }
+}
+
+func each(it *Iterator, yield func(k, v []byte) bool) error {
+	for it.Next() {
+		if !yield(it.Key(), it.Value()) {
+			return nil
+		}
+	}
+	return it.Err()
+}
+
+func dups(cursor *Cursor, key []byte, yield func(k, v []byte) bool) error {
+	k, v, err := cursor.Get(key, nil, SET_KEY)
+	for err == nil {
+		if !yield(k, v) {
+			return nil
+		}
+		k, v, err = cursor.Get(nil, nil, NEXT_DUP)
+	}
+	if err == NotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+//go:build go1.23
+
+package mdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRangeFunc(t *testing.T) {
+	env, dbi := setupIterDB(t, DUPSORT, "a=1", "b=1", "b=2", "ba=3", "c=4")
+	defer clean(env, t)
+
+	collect := func(seq func(yield func(k, v []byte) bool), iterErr func() error) string {
+		var entries []string
+		for k, v := range seq {
+			entries = append(entries, string(k)+"="+string(v))
+		}
+		if err := iterErr(); err != nil {
+			t.Errorf("Unexpected iteration error: %s", err)
+		}
+		return strings.Join(entries, " ")
+	}
+	err := env.View(func(txn *Txn) error {
+		tests := []struct {
+			name     string
+			entries  string
+			expected string
+		}{
+			{"All", collect(txn.All(dbi)), "a=1 b=1 b=2 ba=3 c=4"},
+			{"Backward", collect(txn.Backward(dbi)), "c=4 ba=3 b=2 b=1 a=1"},
+			{"Range", collect(txn.Range(dbi, []byte("b"), []byte("c"))), "b=1 b=2 ba=3"},
+			{"Prefix", collect(txn.Prefix(dbi, []byte("b"))), "b=1 b=2 ba=3"},
+			{"Dups", collect(txn.Dups(dbi, []byte("b"))), "b=1 b=2"},
+			{"Dups missing", collect(txn.Dups(dbi, []byte("x"))), ""},
+		}
+		for _, test := range tests {
+			if test.entries != test.expected {
+				t.Errorf("%s: got %q, expected %q", test.name, test.entries, test.expected)
+			}
+		}
+		n := 0
+		all, _ := txn.All(dbi)
+		for range all {
+			n++
+			if n == 2 {
+				break
+			}
+		}
+		if n != 2 {
+			t.Errorf("Early break visited %d entries", n)
+		}
+
+		cursor, err := txn.CursorOpen(dbi)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close()
+		if entries := collect(cursor.Prefix([]byte("b"))); entries != "b=1 b=2 ba=3" {
+			t.Errorf("Cursor.Prefix: got %q", entries)
+		}
+		if entries := collect(cursor.Dups([]byte("b"))); entries != "b=1 b=2" {
+			t.Errorf("Cursor.Dups: got %q", entries)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestRangeFuncErr(t *testing.T) {
+	env, dbi := setupIterDB(t, 0, "a=1")
+	defer clean(env, t)
+
+	err := env.View(func(txn *Txn) error {
+		bad, badErr := txn.All(DBI(100))
+		good, goodErr := txn.All(dbi)
+		for range bad {
+			t.Errorf("Iterated over an invalid DBI")
+		}
+		for range good {
+		}
+		if badErr() == nil {
+			t.Errorf("Expected an error for an invalid DBI")
+		}
+		if err := goodErr(); err != nil {
+			t.Errorf("Error of another sequence reported: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
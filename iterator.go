@@ -0,0 +1,436 @@
+package mdb
+
+/*
+#cgo CFLAGS: -pthread -W -Wall -Wno-unused-parameter -Wbad-function-cast -O2 -g
+#include "lmdb.h"
+*/
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+// IterOptions restricts and orders the entries visited by an Iterator. Keys
+// are compared with the comparison function of the database. Keys starting
+// with a prefix are only adjacent in the default bytewise order, so Prefix
+// is rejected for databases with REVERSEKEY, INTEGERKEY or a comparator set
+// with SetCompare or SetCompareBuiltin.
+type IterOptions struct {
+	Lower          []byte // Smallest key visited. If nil there is no lower bound.
+	LowerExclusive bool   // Do not visit Lower itself.
+	Upper          []byte // Largest key visited. If nil there is no upper bound.
+	UpperInclusive bool   // Visit Upper itself. By default Upper is excluded.
+	Prefix         []byte // Only visit keys starting with Prefix. Requires the default key order.
+	Reverse        bool   // Visit keys in descending order.
+}
+
+// Iterator walks the entries of a database in key order within the bounds
+// given by IterOptions. A new Iterator is not positioned; the first call to
+// Next moves it to the first entry:
+//
+//	for it.Next() {
+//		fmt.Printf("%s: %s\n", it.Key(), it.Value())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// The methods of an Iterator may be called from multiple goroutines, but the
+// rules of the underlying transaction about threads still apply.
+type Iterator struct {
+	mu       sync.Mutex
+	cursor   *Cursor
+	owned    bool
+	txn      *Txn // set if the iterator checks the context of txn
+	canceled bool
+	opts     IterOptions
+	key      Val
+	val      Val
+	started  bool
+	found    bool // the cursor is at an entry, which may be out of bounds
+	valid    bool // the cursor is at an entry within the bounds
+	err      error
+}
+
+// Iterator opens a cursor on dbi and returns an Iterator over it. The
+// iterator must be closed before the transaction ends. If opts is nil all
+// entries are visited in ascending order.
+//
+// If txn was begun with a context, the context is checked before every
+// cursor step. Once it is done the iterator closes its cursor and reports
+// ctx.Err() from Err. The transaction stays usable and must still be ended
+// by its owner.
+func (txn *Txn) Iterator(dbi DBI, opts *IterOptions) (*Iterator, error) {
+	cursor, err := txn.CursorOpen(dbi)
+	if err != nil {
+		return nil, err
+	}
+	it, err := newIterator(cursor, opts, true)
+	if err != nil {
+		cursor.Close()
+		return nil, err
+	}
+	if txn.ctx != nil {
+		it.txn = txn
+	}
+	return it, nil
+}
+
+var errPrefixOrder = errors.New("Prefix requires the default key order")
+
+func newIterator(cursor *Cursor, opts *IterOptions, owned bool) (*Iterator, error) {
+	it := &Iterator{cursor: cursor, owned: owned}
+	if opts != nil {
+		it.opts = *opts
+	}
+	if it.opts.Prefix != nil {
+		bytewise, err := bytewiseKeys(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if !bytewise {
+			return nil, errPrefixOrder
+		}
+		// Narrow the bounds to the keys starting with the prefix.
+		if it.opts.Lower == nil || bytes.Compare(it.opts.Lower, it.opts.Prefix) < 0 {
+			it.opts.Lower = it.opts.Prefix
+			it.opts.LowerExclusive = false
+		}
+		succ := prefixSuccessor(it.opts.Prefix)
+		if succ != nil && (it.opts.Upper == nil || bytes.Compare(succ, it.opts.Upper) < 0) {
+			it.opts.Upper = succ
+			it.opts.UpperInclusive = false
+		}
+	}
+	return it, nil
+}
+
+// bytewiseKeys reports whether the keys of the database of cursor are in
+// the default bytewise order.
+func bytewiseKeys(cursor *Cursor) (bool, error) {
+	txn := C.mdb_cursor_txn(cursor._cursor)
+	dbi := C.mdb_cursor_dbi(cursor._cursor)
+	var flags C.uint
+	ret := C.mdb_dbi_flags(txn, dbi, &flags)
+	if ret != SUCCESS {
+		return false, errno(ret)
+	}
+	if flags&(REVERSEKEY|INTEGERKEY) != 0 {
+		return false, nil
+	}
+	return !hasCmp(C.mdb_txn_env(txn), DBI(dbi), false), nil
+}
+
+// prefixSuccessor returns the smallest key greater than all keys starting with
+// prefix, or nil if there is none.
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			succ := make([]byte, i+1)
+			copy(succ, prefix)
+			succ[i]++
+			return succ
+		}
+	}
+	return nil
+}
+
+// First moves the iterator to the first entry in iteration order.
+func (it *Iterator) First() bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.closed() {
+		return false
+	}
+	if it.opts.Reverse {
+		return it.toHighest()
+	}
+	return it.toLowest()
+}
+
+// Last moves the iterator to the last entry in iteration order.
+func (it *Iterator) Last() bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.closed() {
+		return false
+	}
+	if it.opts.Reverse {
+		return it.toLowest()
+	}
+	return it.toHighest()
+}
+
+// Seek moves the iterator to the first entry at or after key in iteration
+// order. For reverse iterators this is the largest key not greater than key.
+func (it *Iterator) Seek(key []byte) bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.closed() {
+		return false
+	}
+	if it.opts.Reverse {
+		if !it.inUpper(key) {
+			return it.toHighest()
+		}
+		return it.floor(key)
+	}
+	if !it.inLower(key) {
+		return it.toLowest()
+	}
+	return it.ceil(key)
+}
+
+// Next moves the iterator to the next entry in iteration order. If the
+// iterator is not positioned yet it is moved to the first entry.
+func (it *Iterator) Next() bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.closed() {
+		return false
+	}
+	if !it.started {
+		if it.opts.Reverse {
+			return it.toHighest()
+		}
+		return it.toLowest()
+	}
+	if !it.valid {
+		return false
+	}
+	if it.opts.Reverse {
+		return it.move(nil, PREV)
+	}
+	return it.move(nil, NEXT)
+}
+
+// Prev moves the iterator to the previous entry in iteration order. If the
+// iterator is not positioned yet it is moved to the last entry.
+func (it *Iterator) Prev() bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.closed() {
+		return false
+	}
+	if !it.started {
+		if it.opts.Reverse {
+			return it.toLowest()
+		}
+		return it.toHighest()
+	}
+	if !it.valid {
+		return false
+	}
+	if it.opts.Reverse {
+		return it.move(nil, NEXT)
+	}
+	return it.move(nil, PREV)
+}
+
+// Valid reports whether the iterator is positioned at an entry.
+func (it *Iterator) Valid() bool {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return it.valid
+}
+
+// Key returns a copy of the key of the current entry or nil if the iterator
+// is not positioned at an entry.
+func (it *Iterator) Key() []byte {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if !it.valid {
+		return nil
+	}
+	return it.key.Bytes()
+}
+
+// KeyNoCopy is like Key but returns a slice pointing into the memory map. It
+// is only valid until the transaction ends and must not be modified.
+func (it *Iterator) KeyNoCopy() []byte {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if !it.valid {
+		return nil
+	}
+	return it.key.BytesNoCopy()
+}
+
+// Value returns a copy of the value of the current entry or nil if the
+// iterator is not positioned at an entry.
+func (it *Iterator) Value() []byte {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if !it.valid {
+		return nil
+	}
+	return it.val.Bytes()
+}
+
+// ValueNoCopy is like Value but returns a slice pointing into the memory map.
+// It is only valid until the transaction ends and must not be modified.
+func (it *Iterator) ValueNoCopy() []byte {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if !it.valid {
+		return nil
+	}
+	return it.val.BytesNoCopy()
+}
+
+// Err returns the first error encountered by the iterator. Reaching the end
+// of the entries is not an error.
+func (it *Iterator) Err() error {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return it.err
+}
+
+// Close releases the cursor of the iterator if it was opened by
+// Txn.Iterator.
+func (it *Iterator) Close() error {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.cursor == nil {
+		if it.canceled {
+			return nil
+		}
+		return errors.New("Iterator already closed")
+	}
+	var err error
+	if it.owned {
+		err = it.cursor.Close()
+	}
+	it.cursor = nil
+	it.found = false
+	it.valid = false
+	return err
+}
+
+// closed reports whether the iterator can no longer move because it was
+// closed or its context is done.
+func (it *Iterator) closed() bool {
+	if it.cursor != nil && it.txn != nil && it.txn.ctx.Err() != nil {
+		it.err = it.txn.ctx.Err()
+		it.cursor.Close()
+		it.cursor = nil
+		it.txn = nil
+		it.canceled = true
+	}
+	if it.cursor == nil {
+		it.found = false
+		it.valid = false
+		if it.err == nil {
+			it.err = errors.New("Iterator closed")
+		}
+		return true
+	}
+	return false
+}
+
+// move positions the cursor with op and records the result.
+func (it *Iterator) move(key []byte, op uint) bool {
+	it.started = true
+	k, v, err := it.cursor.GetVal(key, nil, op)
+	if err != nil {
+		it.fail(err)
+		return false
+	}
+	it.key, it.val = k, v
+	it.found = true
+	it.valid = it.inBounds()
+	return it.valid
+}
+
+// fail records that the cursor is not at an entry. NotFound is not reported
+// by Err.
+func (it *Iterator) fail(err error) {
+	it.started = true
+	it.found = false
+	it.valid = false
+	if err != NotFound {
+		it.err = err
+	}
+}
+
+// ceil moves to the smallest key not less than key.
+func (it *Iterator) ceil(key []byte) bool {
+	return it.move(key, SET_RANGE)
+}
+
+// floor moves to the largest key not greater than key.
+func (it *Iterator) floor(key []byte) bool {
+	k, _, err := it.cursor.GetVal(key, nil, SET_RANGE)
+	switch {
+	case err == NotFound:
+		return it.move(nil, LAST)
+	case err != nil:
+		it.fail(err)
+		return false
+	case it.cmp(k, Wrap(key)) > 0:
+		return it.move(nil, PREV)
+	}
+	// Move to the last duplicate of key in DUPSORT databases.
+	_, _, err = it.cursor.GetVal(nil, nil, LAST_DUP)
+	if err != nil && err != Incompatibile {
+		it.fail(err)
+		return false
+	}
+	return it.move(nil, GET_CURRENT)
+}
+
+// toLowest moves to the smallest key within the bounds.
+func (it *Iterator) toLowest() bool {
+	if it.opts.Lower == nil {
+		return it.move(nil, FIRST)
+	}
+	it.ceil(it.opts.Lower)
+	if it.found && it.opts.LowerExclusive && it.cmp(it.key, Wrap(it.opts.Lower)) == 0 {
+		return it.move(nil, NEXT_NODUP)
+	}
+	return it.valid
+}
+
+// toHighest moves to the largest key within the bounds.
+func (it *Iterator) toHighest() bool {
+	if it.opts.Upper == nil {
+		return it.move(nil, LAST)
+	}
+	it.floor(it.opts.Upper)
+	if it.found && !it.opts.UpperInclusive && it.cmp(it.key, Wrap(it.opts.Upper)) == 0 {
+		return it.move(nil, PREV_NODUP)
+	}
+	return it.valid
+}
+
+func (it *Iterator) inLower(key []byte) bool {
+	if it.opts.Lower == nil {
+		return true
+	}
+	c := it.cmp(Wrap(key), Wrap(it.opts.Lower))
+	return c > 0 || c == 0 && !it.opts.LowerExclusive
+}
+
+func (it *Iterator) inUpper(key []byte) bool {
+	if it.opts.Upper == nil {
+		return true
+	}
+	c := it.cmp(Wrap(key), Wrap(it.opts.Upper))
+	return c < 0 || c == 0 && it.opts.UpperInclusive
+}
+
+func (it *Iterator) inBounds() bool {
+	key := it.key.BytesNoCopy()
+	if it.opts.Prefix != nil && !bytes.HasPrefix(key, it.opts.Prefix) {
+		return false
+	}
+	return it.inLower(key) && it.inUpper(key)
+}
+
+// cmp compares two keys with the comparison function of the database.
+func (it *Iterator) cmp(a, b Val) int {
+	c := it.cursor._cursor
+	return int(C.mdb_cmp(C.mdb_cursor_txn(c), C.mdb_cursor_dbi(c), (*C.MDB_val)(&a), (*C.MDB_val)(&b)))
+}
@@ -0,0 +1,226 @@
+package mdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func setupIterDB(t *testing.T, flags uint, kvs ...string) (*Env, DBI) {
+	env := setup(t)
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.DBIOpen(nil, flags)
+		if err != nil {
+			return err
+		}
+		for _, kv := range kvs {
+			p := strings.SplitN(kv, "=", 2)
+			err = txn.Put(dbi, []byte(p[0]), []byte(p[1]), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot fill database: %s", err)
+	}
+	return env, dbi
+}
+
+func iterKeys(t *testing.T, it *Iterator) string {
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Iteration failed: %s", err)
+	}
+	return strings.Join(keys, " ")
+}
+
+func TestIterator(t *testing.T) {
+	env, dbi := setupIterDB(t, 0, "a=1", "b=2", "ba=3", "bb=4", "c=5", "d=6")
+	defer clean(env, t)
+
+	tests := []struct {
+		opts     *IterOptions
+		expected string
+	}{
+		{nil, "a b ba bb c d"},
+		{&IterOptions{Reverse: true}, "d c bb ba b a"},
+		{&IterOptions{Lower: []byte("b"), Upper: []byte("c")}, "b ba bb"},
+		{&IterOptions{Lower: []byte("b"), LowerExclusive: true, Upper: []byte("c"), UpperInclusive: true}, "ba bb c"},
+		{&IterOptions{Lower: []byte("b"), Upper: []byte("c"), Reverse: true}, "bb ba b"},
+		{&IterOptions{Lower: []byte("b"), LowerExclusive: true, Upper: []byte("c"), UpperInclusive: true, Reverse: true}, "c bb ba"},
+		{&IterOptions{Lower: []byte("bz"), Upper: []byte("e")}, "c d"},
+		{&IterOptions{Upper: []byte("bz"), Reverse: true}, "bb ba b a"},
+		{&IterOptions{Prefix: []byte("b")}, "b ba bb"},
+		{&IterOptions{Prefix: []byte("b"), Reverse: true}, "bb ba b"},
+		{&IterOptions{Prefix: []byte("b"), Lower: []byte("ba"), LowerExclusive: true}, "bb"},
+		{&IterOptions{Prefix: []byte("x")}, ""},
+	}
+	err := env.View(func(txn *Txn) error {
+		for _, test := range tests {
+			it, err := txn.Iterator(dbi, test.opts)
+			if err != nil {
+				return err
+			}
+			keys := iterKeys(t, it)
+			if keys != test.expected {
+				t.Errorf("%+v: got %q, expected %q", test.opts, keys, test.expected)
+			}
+			err = it.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestIteratorReverseKey(t *testing.T) {
+	// REVERSEKEY compares keys from their last byte, so the keys starting
+	// with "b" are not adjacent.
+	env, dbi := setupIterDB(t, REVERSEKEY, "ab=1", "b=2", "ba=3", "cb=4")
+	defer clean(env, t)
+
+	err := env.View(func(txn *Txn) error {
+		it, err := txn.Iterator(dbi, &IterOptions{Lower: []byte("b"), Upper: []byte("cb"), UpperInclusive: true})
+		if err != nil {
+			return err
+		}
+		if keys := iterKeys(t, it); keys != "b ab cb" {
+			t.Errorf("Range: got %q", keys)
+		}
+		it.Close()
+		_, err = txn.Iterator(dbi, &IterOptions{Prefix: []byte("b")})
+		if err != errPrefixOrder {
+			t.Errorf("Prefix with REVERSEKEY: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+
+	env2, dbi2 := setupIterDB(t, 0)
+	defer clean(env2, t)
+	err = env2.Update(func(txn *Txn) error {
+		err := txn.SetCompareBuiltin(dbi2, CmpReverseBytes)
+		if err != nil {
+			return err
+		}
+		_, err = txn.Iterator(dbi2, &IterOptions{Prefix: []byte("b")})
+		if err != errPrefixOrder {
+			t.Errorf("Prefix with a comparator: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+}
+
+func TestIteratorSeek(t *testing.T) {
+	env, dbi := setupIterDB(t, 0, "a=1", "b=2", "ba=3", "bb=4", "c=5", "d=6")
+	defer clean(env, t)
+
+	err := env.View(func(txn *Txn) error {
+		it, err := txn.Iterator(dbi, &IterOptions{Upper: []byte("d")})
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		if !it.Seek([]byte("b0")) || string(it.Key()) != "ba" || string(it.Value()) != "3" {
+			t.Errorf("Seek(b0) positioned at %q", it.Key())
+		}
+		if !it.Prev() || string(it.Key()) != "b" {
+			t.Errorf("Prev positioned at %q", it.Key())
+		}
+		if !it.Next() || !it.Next() || string(it.KeyNoCopy()) != "bb" || string(it.ValueNoCopy()) != "4" {
+			t.Errorf("Next positioned at %q", it.Key())
+		}
+		if !it.Last() || string(it.Key()) != "c" {
+			t.Errorf("Last positioned at %q", it.Key())
+		}
+		if it.Next() || it.Valid() || it.Key() != nil {
+			t.Errorf("Next past the upper bound positioned at %q", it.Key())
+		}
+		if it.Seek([]byte("x")) {
+			t.Errorf("Seek past the upper bound positioned at %q", it.Key())
+		}
+
+		rit, err := txn.Iterator(dbi, &IterOptions{Reverse: true})
+		if err != nil {
+			return err
+		}
+		defer rit.Close()
+		if !rit.Seek([]byte("b0")) || string(rit.Key()) != "b" {
+			t.Errorf("reverse Seek(b0) positioned at %q", rit.Key())
+		}
+		if !rit.Seek([]byte("bb")) || string(rit.Key()) != "bb" {
+			t.Errorf("reverse Seek(bb) positioned at %q", rit.Key())
+		}
+		if !rit.Next() || string(rit.Key()) != "ba" {
+			t.Errorf("reverse Next positioned at %q", rit.Key())
+		}
+		return it.Err()
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestIteratorDupSort(t *testing.T) {
+	env, dbi := setupIterDB(t, DUPSORT, "a=1", "b=1", "b=2", "b=3", "c=1")
+	defer clean(env, t)
+
+	err := env.View(func(txn *Txn) error {
+		it, err := txn.Iterator(dbi, &IterOptions{Upper: []byte("b"), UpperInclusive: true, Reverse: true})
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		var entries []string
+		for it.Next() {
+			entries = append(entries, string(it.Key())+"="+string(it.Value()))
+		}
+		if strings.Join(entries, " ") != "b=3 b=2 b=1 a=1" {
+			t.Errorf("Unexpected entries: %q", entries)
+		}
+		return it.Err()
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestIteratorClose(t *testing.T) {
+	env, dbi := setupIterDB(t, 0, "a=1")
+	defer clean(env, t)
+
+	err := env.View(func(txn *Txn) error {
+		it, err := txn.Iterator(dbi, nil)
+		if err != nil {
+			return err
+		}
+		err = it.Close()
+		if err != nil {
+			return err
+		}
+		if it.Next() || it.Err() == nil {
+			t.Errorf("Closed iterator can be used")
+		}
+		if it.Close() == nil {
+			t.Errorf("Iterator closed twice")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
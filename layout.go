@@ -0,0 +1,119 @@
+package mdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"strconv"
+	"unsafe"
+)
+
+// The layout of the data file of a 64-bit build of LMDB 0.9, from MDB_meta
+// and MDB_db in mdb.c. Backups and the consistency check read it directly.
+// The fields are stored in the byte order of the host that wrote the file.
+
+// Offsets in a meta page.
+const (
+	metaMagicOff  = 16
+	metaDBsOff    = 40 // MDB_db records of the free list and the main database
+	metaPSizeOff  = 40 // md_pad of the free list holds the page size
+	metaLastPgOff = 136
+	metaTxnIDOff  = 144
+	metaSize      = 152
+	metaMagic     = 0xBEEFC0DE
+)
+
+// Offsets in an MDB_db record, which describes a database in a meta page or
+// in the value of a named database in the main database.
+const (
+	dbFlagsOff    = 4
+	dbDepthOff    = 6
+	dbBranchOff   = 8
+	dbLeafOff     = 16
+	dbOverflowOff = 24
+	dbEntriesOff  = 32
+	dbRootOff     = 40
+	dbRecordSize  = 48
+)
+
+// Offsets and flags of pages, from MDB_page in mdb.c. A page starts with a
+// header followed by the offsets of its nodes.
+const (
+	pageNumberOff   = 0
+	pagePadOff      = 8 // size of the keys of a LEAF2 page
+	pageFlagsOff    = 10
+	pageLowerOff    = 12
+	pageUpperOff    = 14
+	pageOverflowOff = 12 // number of pages of an overflow page
+	pageHeaderSize  = 16
+
+	pageBranch   = 0x01
+	pageLeaf     = 0x02
+	pageOverflow = 0x04
+	pageLeaf2    = 0x20 // keys of fixed size without nodes
+	pageSub      = 0x40 // sub-page of duplicates stored in a node
+
+	invalidPgno = ^uint64(0) // root of an empty database
+)
+
+// Offsets and flags of nodes, from MDB_node in mdb.c. The 32-bit word at
+// the start of a node is the size of its data, or in a branch page the low
+// bits of the child page number, whose high bits are the node flags.
+const (
+	nodeFlagsOff   = 4
+	nodeKeySizeOff = 6
+	nodeSize       = 8
+
+	nodeBigData = 0x01 // data is the number of an overflow page
+	nodeSubData = 0x02 // data is an MDB_db record
+	nodeDupData = 0x04 // data holds duplicates
+)
+
+// mainDBI is the handle of the main database.
+const mainDBI DBI = 1
+
+// metaDBOff returns the offset of the MDB_db record of dbi, which is
+// FREE_DBI or mainDBI, in a meta page.
+func metaDBOff(dbi DBI) int {
+	return metaDBsOff + int(dbi)*dbRecordSize
+}
+
+// nativeEndian is the byte order of the host.
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+var errDataFormat = errors.New("Unsupported data file format")
+
+// checkLayout returns an error if the data file cannot be read with the
+// layout above.
+func checkLayout() error {
+	if strconv.IntSize != 64 {
+		return errors.New("Reading the data file requires a 64-bit build")
+	}
+	return nil
+}
+
+// checkMeta returns an error if p is not a meta page of the layout above.
+func checkMeta(p []byte) error {
+	err := checkLayout()
+	if err != nil {
+		return err
+	}
+	if len(p) < metaSize {
+		return errDataFormat
+	}
+	magic := nativeEndian.Uint32(p[metaMagicOff:])
+	if magic != metaMagic {
+		if bits.ReverseBytes32(magic) == metaMagic {
+			return errors.New("Data file was written by a host of the other byte order")
+		}
+		return errDataFormat
+	}
+	return nil
+}
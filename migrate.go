@@ -0,0 +1,262 @@
+package mdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// SchemaTooNew is returned by Migrator methods when the schema version of the
+// environment is newer than the latest migration.
+var SchemaTooNew = errors.New("Schema version is newer than the latest migration")
+
+// Meta database keys of the schema version and the checkpoint of an
+// interrupted chunked migration.
+var (
+	schemaVersionKey    = []byte("schema:version")
+	schemaCheckpointKey = []byte("schema:checkpoint")
+)
+
+// ChunkFunc runs one chunk of a migration. It is called with nil to run the
+// first chunk and with the checkpoint returned by the previous chunk
+// otherwise. It returns nil once the migration is done.
+type ChunkFunc func(txn *Txn, checkpoint []byte) (next []byte, err error)
+
+// Migration changes the data of an environment from Version-1 to Version.
+// Exactly one of Up and Chunk must be set. Up runs in a single write
+// transaction. Chunk runs in a write transaction per chunk, and the
+// checkpoint is committed with each chunk so that an interrupted migration
+// continues where it stopped.
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      TxnOp
+	Chunk   ChunkFunc
+}
+
+// MigrationStatus describes the migrations of an environment.
+type MigrationStatus struct {
+	Version    uint64      // Schema version of the environment.
+	Latest     uint64      // Version of the latest migration.
+	Pending    []Migration // Migrations not applied yet.
+	Checkpoint []byte      // Checkpoint of an interrupted chunked migration.
+}
+
+// Migrator applies migrations to an environment. The schema version is
+// stored in the metadata database, which counts against SetMaxDBs.
+type Migrator struct {
+	env        *Env
+	migrations []Migration
+}
+
+// NewMigrator returns a Migrator for migrations. The versions of the
+// migrations must be 1, 2, 3 and so on, in any order.
+func (env *Env) NewMigrator(migrations ...Migration) (*Migrator, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i, m := range sorted {
+		if m.Version != uint64(i+1) {
+			return nil, fmt.Errorf("Migration versions are not consecutive at %d", m.Version)
+		}
+		if (m.Up == nil) == (m.Chunk == nil) {
+			return nil, fmt.Errorf("Migration %d must have either Up or Chunk", m.Version)
+		}
+	}
+	return &Migrator{env: env, migrations: sorted}, nil
+}
+
+// Latest returns the version of the latest migration.
+func (m *Migrator) Latest() uint64 {
+	return uint64(len(m.migrations))
+}
+
+// Open opens the environment of the migrator like Env.Open and returns
+// SchemaTooNew if its schema version is newer than the latest migration, so
+// that a program does not use data written by a newer version of itself.
+// The migrator may be created before the environment is opened. If Open
+// fails the environment must be closed.
+func (m *Migrator) Open(path string, flags uint, mode uint) error {
+	err := m.env.Open(path, flags, mode)
+	if err != nil {
+		return err
+	}
+	return m.Check()
+}
+
+// Check returns SchemaTooNew if the schema version of the environment is
+// newer than the latest migration. Programs should refuse to use such an
+// environment; Open and Migrate do.
+func (m *Migrator) Check() error {
+	_, err := m.Status()
+	return err
+}
+
+// Status returns the schema version and the pending migrations.
+func (m *Migrator) Status() (*MigrationStatus, error) {
+	var status *MigrationStatus
+	err := m.env.View(func(txn *Txn) (err error) {
+		status, err = m.status(txn)
+		return err
+	})
+	return status, err
+}
+
+func (m *Migrator) status(txn *Txn) (*MigrationStatus, error) {
+	version, err := schemaVersion(txn)
+	if err != nil {
+		return nil, err
+	}
+	status := &MigrationStatus{Version: version, Latest: m.Latest()}
+	if version > status.Latest {
+		return status, SchemaTooNew
+	}
+	status.Pending = append([]Migration(nil), m.migrations[version:]...)
+	status.Checkpoint, err = schemaCheckpoint(txn, version+1)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Migrate applies the pending migrations in order. A migration that fails
+// is rolled back, or, for chunked migrations, stopped at the last committed
+// chunk; its error is returned and the following migrations are not run.
+// SchemaTooNew is returned without changing anything if the schema version
+// is newer than the latest migration.
+func (m *Migrator) Migrate() error {
+	for {
+		var next *Migration
+		err := m.env.Update(func(txn *Txn) error {
+			status, err := m.status(txn)
+			if err != nil {
+				return err
+			}
+			if len(status.Pending) == 0 {
+				return nil
+			}
+			next = &status.Pending[0]
+			if next.Up == nil {
+				_, err = m.chunk(txn, next, status.Checkpoint)
+				return err
+			}
+			return m.up(txn, next)
+		})
+		if err != nil {
+			return migrationError(next, err)
+		}
+		if next == nil {
+			return nil
+		}
+	}
+}
+
+// DryRun applies the pending migrations in a single write transaction that
+// is aborted, and returns the migrations it ran. Chunked migrations run all
+// their chunks.
+func (m *Migrator) DryRun() ([]Migration, error) {
+	var ran []Migration
+	txn, err := m.env.BeginTxn(nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Abort()
+	status, err := m.status(txn)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := status.Checkpoint
+	for i := range status.Pending {
+		mig := &status.Pending[i]
+		if mig.Up != nil {
+			err = m.up(txn, mig)
+		} else {
+			for {
+				checkpoint, err = m.chunk(txn, mig, checkpoint)
+				if err != nil || checkpoint == nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return ran, migrationError(mig, err)
+		}
+		ran = append(ran, *mig)
+	}
+	return ran, nil
+}
+
+func migrationError(mig *Migration, err error) error {
+	if mig == nil || err == SchemaTooNew {
+		return err
+	}
+	return fmt.Errorf("Migration %d %s: %s", mig.Version, mig.Name, err)
+}
+
+func (m *Migrator) up(txn *Txn, mig *Migration) error {
+	err := mig.Up(txn)
+	if err != nil {
+		return err
+	}
+	return setSchemaVersion(txn, mig.Version)
+}
+
+// chunk runs one chunk of mig and records its checkpoint, or the new schema
+// version if mig is done.
+func (m *Migrator) chunk(txn *Txn, mig *Migration, checkpoint []byte) ([]byte, error) {
+	next, err := mig.Chunk(txn, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := txn.openMeta(true)
+	if err != nil {
+		return nil, err
+	}
+	if next == nil {
+		err = txn.Del(meta, schemaCheckpointKey, nil)
+		if err != nil && err != NotFound {
+			return nil, err
+		}
+		return nil, setSchemaVersion(txn, mig.Version)
+	}
+	val := make([]byte, 8+len(next))
+	binary.BigEndian.PutUint64(val, mig.Version)
+	copy(val[8:], next)
+	return next, txn.Put(meta, schemaCheckpointKey, val, 0)
+}
+
+func schemaVersion(txn *Txn) (uint64, error) {
+	val, err := getMeta(txn, schemaVersionKey)
+	if err != nil || val == nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, errors.New("Invalid schema version")
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+func setSchemaVersion(txn *Txn, version uint64) error {
+	meta, err := txn.openMeta(true)
+	if err != nil {
+		return err
+	}
+	var val [8]byte
+	binary.BigEndian.PutUint64(val[:], version)
+	return txn.Put(meta, schemaVersionKey, val[:], 0)
+}
+
+// schemaCheckpoint returns the checkpoint of the migration to version, or
+// nil if there is none.
+func schemaCheckpoint(txn *Txn, version uint64) ([]byte, error) {
+	val, err := getMeta(txn, schemaCheckpointKey)
+	if err != nil || val == nil {
+		return nil, err
+	}
+	if len(val) < 8 || binary.BigEndian.Uint64(val) != version {
+		return nil, errors.New("Invalid migration checkpoint")
+	}
+	return val[8:], nil
+}
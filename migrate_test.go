@@ -0,0 +1,157 @@
+package mdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMigrator(t *testing.T) {
+	env := setupMaxDBs(t, 2)
+	defer clean(env, t)
+
+	name := "items"
+	var dbi DBI
+	create := Migration{Version: 1, Name: "create", Up: func(txn *Txn) (err error) {
+		dbi, err = txn.DBIOpen(&name, CREATE)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10; i++ {
+			err = txn.Put(dbi, []byte(fmt.Sprintf("%d", i)), []byte("v"), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+	// Rewrite values three keys at a time, failing once after the second
+	// chunk.
+	failed := false
+	chunks := 0
+	rewrite := Migration{Version: 2, Name: "rewrite", Chunk: func(txn *Txn, checkpoint []byte) ([]byte, error) {
+		chunks++
+		var next uint64
+		if checkpoint != nil {
+			next = binary.BigEndian.Uint64(checkpoint)
+		}
+		if next == 6 && !failed {
+			failed = true
+			return nil, errors.New("interrupted")
+		}
+		for i := next; i < next+3 && i < 10; i++ {
+			err := txn.Put(dbi, []byte(fmt.Sprintf("%d", i)), []byte("w"), 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if next+3 >= 10 {
+			return nil, nil
+		}
+		checkpoint = make([]byte, 8)
+		binary.BigEndian.PutUint64(checkpoint, next+3)
+		return checkpoint, nil
+	}}
+
+	if _, err := env.NewMigrator(rewrite); err == nil {
+		t.Errorf("Migrator without version 1 created")
+	}
+	m, err := env.NewMigrator(rewrite, create)
+	if err != nil {
+		t.Fatalf("Cannot create migrator: %s", err)
+	}
+	status, err := m.Status()
+	if err != nil {
+		t.Fatalf("Cannot get status: %s", err)
+	}
+	if status.Version != 0 || status.Latest != 2 || len(status.Pending) != 2 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	ran, err := m.DryRun()
+	if err == nil || len(ran) != 1 {
+		t.Errorf("Unexpected dry run: %v, %v", ran, err)
+	}
+	failed = true
+	ran, err = m.DryRun()
+	if err != nil || len(ran) != 2 {
+		t.Errorf("Unexpected dry run: %v, %v", ran, err)
+	}
+	status, err = m.Status()
+	if err != nil || status.Version != 0 {
+		t.Errorf("Dry run changed the schema version: %+v, %v", status, err)
+	}
+
+	failed = false
+	err = m.Migrate()
+	if err == nil {
+		t.Fatalf("Interrupted migration succeeded")
+	}
+	status, err = m.Status()
+	if err != nil {
+		t.Fatalf("Cannot get status: %s", err)
+	}
+	if status.Version != 1 || len(status.Pending) != 1 || binary.BigEndian.Uint64(status.Checkpoint) != 6 {
+		t.Errorf("Unexpected status after interruption: %+v", status)
+	}
+	chunks = 0
+	err = m.Migrate()
+	if err != nil {
+		t.Fatalf("Cannot migrate: %s", err)
+	}
+	if chunks != 2 {
+		t.Errorf("Migration resumed with %d chunks", chunks)
+	}
+	err = env.View(func(txn *Txn) error {
+		keys, vals := scanKeyVals(t, txn, dbi)
+		for i := range keys {
+			if string(vals[i]) != "w" {
+				t.Errorf("Value of %s not migrated", keys[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+
+	old, err := env.NewMigrator(create)
+	if err != nil {
+		t.Fatalf("Cannot create migrator: %s", err)
+	}
+	if err = old.Check(); err != SchemaTooNew {
+		t.Errorf("Check of an older migrator returned %v", err)
+	}
+	if err = old.Migrate(); err != SchemaTooNew {
+		t.Errorf("Migrate of an older migrator returned %v", err)
+	}
+
+	path, err := ioutil.TempDir("/tmp", "mdb_test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(path)
+	err = env.Copy(path)
+	if err != nil {
+		t.Fatalf("Cannot copy environment: %s", err)
+	}
+	reopened, err := NewEnv()
+	if err != nil {
+		t.Fatalf("Cannot create environment: %s", err)
+	}
+	defer reopened.Close()
+	err = reopened.SetMaxDBs(2)
+	if err != nil {
+		t.Fatalf("Cannot set maxdbs: %s", err)
+	}
+	old, err = reopened.NewMigrator(create)
+	if err != nil {
+		t.Fatalf("Cannot create migrator: %s", err)
+	}
+	if err = old.Open(path, 0, 0664); err != SchemaTooNew {
+		t.Errorf("Open of an older migrator returned %v", err)
+	}
+}
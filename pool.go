@@ -0,0 +1,163 @@
+package mdb
+
+import (
+	"errors"
+	"sync"
+)
+
+// ReadTxnPool hands out read-only transactions and keeps them for reuse
+// instead of aborting them. Released transactions are reset, which frees
+// their snapshot but keeps their reader slot, and renewed when they are
+// handed out again. Cursors obtained with Cursor are kept with their
+// transaction and renewed along with it.
+//
+// A ReadTxnPool may be used from multiple goroutines. Transactions and
+// cursors obtained from it must not be aborted or closed by the caller.
+type ReadTxnPool struct {
+	env    *Env
+	max    int
+	mu     sync.Mutex
+	idle   []*Txn
+	txns   map[*Txn]*pooledTxn // all transactions created by the pool
+	begins int                 // slots reserved by Get for transactions being begun
+	closed bool
+}
+
+type pooledTxn struct {
+	cursors map[DBI]*Cursor
+	stale   map[DBI]bool // cursors not yet renewed since the last Renew
+	idle    bool         // the transaction was put back
+}
+
+// NewReadTxnPool creates a pool holding at most max transactions. If max is
+// zero the limit is the maximum number of readers of the environment.
+func (env *Env) NewReadTxnPool(max int) (*ReadTxnPool, error) {
+	if max <= 0 {
+		info, err := env.Info()
+		if err != nil {
+			return nil, err
+		}
+		max = int(info.MaxReaders)
+	}
+	return &ReadTxnPool{env: env, max: max, txns: map[*Txn]*pooledTxn{}}, nil
+}
+
+// Get returns a read-only transaction on a fresh snapshot. It must be
+// released with Put. ReadersFull is returned if the pool already handed out
+// its maximum number of transactions.
+func (pool *ReadTxnPool) Get() (*Txn, error) {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return nil, errors.New("Pool closed")
+	}
+	var txn *Txn
+	if n := len(pool.idle); n > 0 {
+		txn = pool.idle[n-1]
+		pool.idle = pool.idle[:n-1]
+		pool.txns[txn].idle = false
+	} else if len(pool.txns)+pool.begins >= pool.max {
+		pool.mu.Unlock()
+		return nil, ReadersFull
+	} else {
+		pool.begins++
+	}
+	pool.mu.Unlock()
+
+	if txn == nil {
+		txn, err := pool.env.BeginTxn(nil, RDONLY)
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		pool.begins--
+		if err != nil {
+			return nil, err
+		}
+		pool.txns[txn] = &pooledTxn{cursors: map[DBI]*Cursor{}, stale: map[DBI]bool{}}
+		return txn, nil
+	}
+	err := txn.Renew()
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if err != nil {
+		pool.destroy(txn)
+		return nil, err
+	}
+	ptxn := pool.txns[txn]
+	for dbi := range ptxn.cursors {
+		ptxn.stale[dbi] = true
+	}
+	return txn, nil
+}
+
+// Put resets txn and returns it to the pool. An error is returned if txn
+// was not obtained from the pool with Get or was already put back.
+func (pool *ReadTxnPool) Put(txn *Txn) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	ptxn, ok := pool.txns[txn]
+	if !ok {
+		return errors.New("Transaction not from this pool")
+	}
+	if ptxn.idle {
+		return errors.New("Transaction already put back")
+	}
+	if pool.closed {
+		pool.destroy(txn)
+		return nil
+	}
+	txn.Reset()
+	ptxn.idle = true
+	pool.idle = append(pool.idle, txn)
+	return nil
+}
+
+// Cursor returns a cursor on dbi for a transaction obtained from Get. The
+// cursor is kept with the transaction and reused by later calls.
+func (pool *ReadTxnPool) Cursor(txn *Txn, dbi DBI) (*Cursor, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	ptxn, ok := pool.txns[txn]
+	if !ok {
+		return nil, errors.New("Transaction not from this pool")
+	}
+	cursor, ok := ptxn.cursors[dbi]
+	if !ok {
+		cursor, err := txn.CursorOpen(dbi)
+		if err != nil {
+			return nil, err
+		}
+		ptxn.cursors[dbi] = cursor
+		return cursor, nil
+	}
+	if ptxn.stale[dbi] {
+		err := txn.CursorRenew(cursor)
+		if err != nil {
+			return nil, err
+		}
+		delete(ptxn.stale, dbi)
+	}
+	return cursor, nil
+}
+
+// Close aborts the idle transactions of the pool. Transactions in use are
+// aborted when they are put back.
+func (pool *ReadTxnPool) Close() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.closed = true
+	for _, txn := range pool.idle {
+		pool.destroy(txn)
+	}
+	pool.idle = nil
+}
+
+// destroy closes the cursors of txn and aborts it. pool.mu must be held.
+func (pool *ReadTxnPool) destroy(txn *Txn) {
+	if ptxn, ok := pool.txns[txn]; ok {
+		for _, cursor := range ptxn.cursors {
+			cursor.Close()
+		}
+		delete(pool.txns, txn)
+	}
+	txn.Abort()
+}
@@ -0,0 +1,137 @@
+package mdb
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestReadTxnPool(t *testing.T) {
+	env, dbi := setupIterDB(t, 0, "a=1", "b=2")
+	defer clean(env, t)
+
+	pool, err := env.NewReadTxnPool(2)
+	if err != nil {
+		t.Fatalf("Cannot create pool: %s", err)
+	}
+	txn1, err := pool.Get()
+	if err != nil {
+		t.Fatalf("Cannot get transaction: %s", err)
+	}
+	cursor, err := pool.Cursor(txn1, dbi)
+	if err != nil {
+		t.Fatalf("Cannot get cursor: %s", err)
+	}
+	txn2, err := pool.Get()
+	if err != nil {
+		t.Fatalf("Cannot get transaction: %s", err)
+	}
+	_, err = pool.Get()
+	if err != ReadersFull {
+		t.Errorf("Expected ReadersFull from a full pool: %v", err)
+	}
+	for _, txn := range []*Txn{txn2, txn1} {
+		err = pool.Put(txn)
+		if err != nil {
+			t.Fatalf("Cannot put transaction: %s", err)
+		}
+	}
+	err = pool.Put(txn1)
+	if err == nil {
+		t.Errorf("Transaction put back twice")
+	}
+	foreign, err := env.BeginTxn(nil, RDONLY)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction: %s", err)
+	}
+	err = pool.Put(foreign)
+	if err == nil {
+		t.Errorf("Foreign transaction put into the pool")
+	}
+	foreign.Abort()
+
+	err = env.Update(func(txn *Txn) error {
+		return txn.Put(dbi, []byte("c"), []byte("3"), 0)
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+
+	txn, err := pool.Get()
+	if err != nil {
+		t.Fatalf("Cannot get transaction: %s", err)
+	}
+	if txn != txn1 {
+		t.Errorf("Pool did not reuse the last released transaction")
+	}
+	c, err := pool.Cursor(txn, dbi)
+	if err != nil {
+		t.Fatalf("Cannot get cursor: %s", err)
+	}
+	if c != cursor {
+		t.Errorf("Pool did not reuse the cursor")
+	}
+	k, v, err := c.Get(nil, nil, LAST)
+	if err != nil || string(k) != "c" || string(v) != "3" {
+		t.Errorf("Renewed cursor does not see the new snapshot: %q=%q %v", k, v, err)
+	}
+	pool.Put(txn)
+	pool.Close()
+
+	_, err = pool.Get()
+	if err == nil {
+		t.Errorf("Closed pool handed out a transaction")
+	}
+	readers, err := env.Readers()
+	if err != nil {
+		t.Fatalf("Cannot list readers: %s", err)
+	}
+	if len(readers) != 0 {
+		t.Errorf("Closed pool left readers: %+v", readers)
+	}
+}
+
+func TestReadTxnPoolConcurrentGet(t *testing.T) {
+	env, _ := setupIterDB(t, 0, "a=1")
+	defer clean(env, t)
+
+	const max = 3
+	pool, err := env.NewReadTxnPool(max)
+	if err != nil {
+		t.Fatalf("Cannot create pool: %s", err)
+	}
+	defer pool.Close()
+	var tried, done sync.WaitGroup
+	var mu sync.Mutex
+	got := 0
+	for i := 0; i < 20; i++ {
+		tried.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			txn, err := pool.Get()
+			if err == nil {
+				mu.Lock()
+				got++
+				mu.Unlock()
+			} else if err != ReadersFull {
+				t.Errorf("Cannot get transaction: %s", err)
+			}
+			// Hold the transaction until every goroutine tried to get one.
+			tried.Done()
+			tried.Wait()
+			if txn != nil {
+				pool.Put(txn)
+			}
+		}()
+	}
+	done.Wait()
+	if got > max {
+		t.Errorf("Pool handed out %d transactions, max %d", got, max)
+	}
+	pool.mu.Lock()
+	n := len(pool.txns)
+	pool.mu.Unlock()
+	if n > max {
+		t.Errorf("Pool created %d transactions, max %d", n, max)
+	}
+}
@@ -0,0 +1,105 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Frame types. A frame is its type, the uvarint length of its payload and
+// the payload.
+const (
+	frameHello       = 1 // follower: bootstrapped flag and position
+	frameSnapshot    = 2 // primary: a chunk of the snapshot
+	frameSnapshotEnd = 3 // primary: end of the snapshot
+	frameChanges     = 4 // primary: a marshaled mdb.ChangeSet
+	frameHeartbeat   = 5 // primary: ID of its last change set
+)
+
+const (
+	maxFramePayload   = 1 << 30
+	snapshotChunkSize = 1 << 16
+)
+
+var errFrameTooLarge = errors.New("Frame too large")
+
+type frameWriter struct {
+	w *bufio.Writer
+}
+
+func newFrameWriter(w io.Writer) *frameWriter {
+	return &frameWriter{bufio.NewWriter(w)}
+}
+
+// write writes a frame. It is buffered until flush.
+func (fw *frameWriter) write(typ byte, payload []byte) error {
+	var hdr [1 + binary.MaxVarintLen64]byte
+	hdr[0] = typ
+	n := binary.PutUvarint(hdr[1:], uint64(len(payload)))
+	_, err := fw.w.Write(hdr[:1+n])
+	if err != nil {
+		return err
+	}
+	_, err = fw.w.Write(payload)
+	return err
+}
+
+func (fw *frameWriter) flush() error {
+	return fw.w.Flush()
+}
+
+// writeUvarint writes a frame with a single uvarint payload.
+func (fw *frameWriter) writeUvarint(typ byte, v uint64) error {
+	var p [binary.MaxVarintLen64]byte
+	return fw.write(typ, p[:binary.PutUvarint(p[:], v)])
+}
+
+type frameReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{r: bufio.NewReader(r)}
+}
+
+// read returns the next frame. The payload is only valid until the next
+// call.
+func (fr *frameReader) read() (byte, []byte, error) {
+	typ, err := fr.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := binary.ReadUvarint(fr.r)
+	if err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	if n > maxFramePayload {
+		return 0, nil, errFrameTooLarge
+	}
+	if uint64(cap(fr.buf)) < n {
+		fr.buf = make([]byte, n)
+	}
+	p := fr.buf[:n]
+	_, err = io.ReadFull(fr.r, p)
+	if err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	return typ, p, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func uvarint(p []byte) (uint64, error) {
+	v, n := binary.Uvarint(p)
+	if n <= 0 {
+		return 0, errors.New("Invalid frame")
+	}
+	return v, nil
+}
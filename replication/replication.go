@@ -0,0 +1,598 @@
+// Package replication copies the committed writes of a primary environment
+// to follower environments.
+//
+// The primary records its writes with the changelog of the mdb package. A
+// follower connects over any io.ReadWriter, such as a TCP connection, and
+// sends the ID of the last change set it applied. A new follower, or one
+// that is behind the trimmed part of the changelog, is first sent a
+// snapshot of the primary. Afterwards the primary streams the change sets
+// in commit order, and the follower applies each in a write transaction
+// together with its new position, so change sets that are sent again after
+// a reconnect are skipped.
+//
+// Followers must not be written to by other means.
+package replication
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	mdb "github.com/szferi/gomdb"
+)
+
+// PrimaryOptions controls a Primary.
+type PrimaryOptions struct {
+	Heartbeat time.Duration // Interval of heartbeats reporting the position of the primary.
+	Compact   bool          // Send compacted snapshots.
+}
+
+// DefaultPrimaryOptions are used by NewPrimary if opts is nil.
+var DefaultPrimaryOptions = PrimaryOptions{Heartbeat: time.Second}
+
+// Primary serves the change sets of an environment to followers.
+type Primary struct {
+	env  *mdb.Env
+	opts PrimaryOptions
+}
+
+// NewPrimary returns a Primary for env and enables its changelog. Only the
+// writes of transactions begun afterwards are replicated, so the Primary
+// should be created before env is written to.
+func NewPrimary(env *mdb.Env, opts *PrimaryOptions) *Primary {
+	env.EnableChangelog()
+	p := &Primary{env: env, opts: DefaultPrimaryOptions}
+	if opts != nil {
+		p.opts = *opts
+	}
+	if p.opts.Heartbeat <= 0 {
+		p.opts.Heartbeat = DefaultPrimaryOptions.Heartbeat
+	}
+	return p
+}
+
+// closeOnDone closes conn when ctx is done if conn is an io.Closer, which
+// interrupts blocked reads and writes. The returned function stops it.
+func closeOnDone(ctx context.Context, conn io.ReadWriter) func() {
+	c, ok := conn.(io.Closer)
+	if !ok {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// ctxErr returns the error of ctx if it is done, otherwise err.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Serve serves one follower connected with conn until ctx is done or the
+// connection fails.
+func (p *Primary) Serve(ctx context.Context, conn io.ReadWriter) error {
+	defer closeOnDone(ctx, conn)()
+	// Subscribe before reading the changelog so that no commit is missed.
+	notify, cancel, err := p.env.SubscribeChanges(1)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	fr := newFrameReader(conn)
+	fw := newFrameWriter(conn)
+	var pos uint64
+	for {
+		var bootstrapped bool
+		pos, bootstrapped, err = readHello(fr)
+		if err != nil {
+			return ctxErr(ctx, err)
+		}
+		if bootstrapped {
+			trimmed, err := p.trimmed()
+			if err != nil {
+				return err
+			}
+			if pos >= trimmed {
+				break
+			}
+		}
+		err = p.sendSnapshot(fw)
+		if err != nil {
+			return ctxErr(ctx, err)
+		}
+	}
+	heartbeat := time.NewTicker(p.opts.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		batch, last, err := p.changes(pos)
+		if err != nil {
+			return err
+		}
+		for _, cs := range batch {
+			payload, err := cs.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			err = fw.write(frameChanges, payload)
+			if err != nil {
+				return ctxErr(ctx, err)
+			}
+			pos = cs.TxnID
+		}
+		if len(batch) == 0 {
+			err = fw.writeUvarint(frameHeartbeat, last)
+			if err == nil {
+				err = fw.flush()
+			}
+			if err != nil {
+				return ctxErr(ctx, err)
+			}
+			select {
+			case _, ok := <-notify:
+				if !ok {
+					return errors.New("Environment closed")
+				}
+			case <-heartbeat.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (p *Primary) trimmed() (uint64, error) {
+	var trimmed uint64
+	err := p.env.View(func(txn *mdb.Txn) (err error) {
+		trimmed, err = txn.ChangesTrimmed()
+		return err
+	})
+	return trimmed, err
+}
+
+var errBatchFull = errors.New("batch full")
+
+// changesPerBatch is the number of change sets read in one read
+// transaction.
+const changesPerBatch = 256
+
+// changes returns the change sets after pos and the ID of the last change
+// set of the primary. It fails if change sets after pos were trimmed.
+func (p *Primary) changes(pos uint64) (batch []*mdb.ChangeSet, last uint64, err error) {
+	err = p.env.View(func(txn *mdb.Txn) error {
+		trimmed, err := txn.ChangesTrimmed()
+		if err != nil {
+			return err
+		}
+		if pos < trimmed {
+			return errors.New("Follower is behind the trimmed changelog")
+		}
+		last, err = txn.LastChange()
+		if err != nil {
+			return err
+		}
+		err = txn.Changes(pos, func(cs *mdb.ChangeSet) error {
+			batch = append(batch, cs)
+			if len(batch) == changesPerBatch {
+				return errBatchFull
+			}
+			return nil
+		})
+		if err == errBatchFull {
+			return nil
+		}
+		return err
+	})
+	return batch, last, err
+}
+
+// snapshotWriter sends the data written to it as snapshot frames.
+type snapshotWriter struct {
+	fw *frameWriter
+}
+
+func (w snapshotWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > snapshotChunkSize {
+			chunk = chunk[:snapshotChunkSize]
+		}
+		err := w.fw.write(frameSnapshot, chunk)
+		if err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+func (p *Primary) sendSnapshot(fw *frameWriter) error {
+	err := p.env.CopyTo(snapshotWriter{fw}, p.opts.Compact)
+	if err != nil {
+		return err
+	}
+	err = fw.write(frameSnapshotEnd, nil)
+	if err != nil {
+		return err
+	}
+	return fw.flush()
+}
+
+func readHello(fr *frameReader) (pos uint64, bootstrapped bool, err error) {
+	typ, payload, err := fr.read()
+	if err != nil {
+		return 0, false, err
+	}
+	if typ != frameHello || len(payload) < 1 {
+		return 0, false, errors.New("Expected hello frame")
+	}
+	pos, err = uvarint(payload[1:])
+	return pos, payload[0] != 0, err
+}
+
+func writeHello(fw *frameWriter, pos uint64, bootstrapped bool) error {
+	payload := make([]byte, 1, 1+binary.MaxVarintLen64)
+	if bootstrapped {
+		payload[0] = 1
+	}
+	var p [binary.MaxVarintLen64]byte
+	payload = append(payload, p[:binary.PutUvarint(p[:], pos)]...)
+	err := fw.write(frameHello, payload)
+	if err != nil {
+		return err
+	}
+	return fw.flush()
+}
+
+// FollowerOptions controls how a Follower opens its environment.
+type FollowerOptions struct {
+	MaxDBs  mdb.DBI // Maximum number of named databases, including the ones used internally.
+	MapSize uint64  // Size of the memory map. If zero the LMDB default is used.
+}
+
+// Status describes the progress of a Follower.
+type Status struct {
+	Position uint64    // ID of the last applied change set.
+	Primary  uint64    // ID of the last change set reported by the primary.
+	Applied  time.Time // Time the last change set was applied.
+}
+
+// Lag returns the difference between the last transaction ID reported by
+// the primary and the applied one.
+func (s Status) Lag() uint64 {
+	if s.Primary <= s.Position {
+		return 0
+	}
+	return s.Primary - s.Position
+}
+
+// Follower is an environment kept up to date with a Primary.
+type Follower struct {
+	path string
+	opts FollowerOptions
+
+	mu  sync.RWMutex // held for writing while the environment is replaced
+	env *mdb.Env
+
+	statusMu     sync.Mutex
+	status       Status
+	bootstrapped bool
+}
+
+// replDBName is the database in which a follower stores its position.
+const replDBName = "__gomdb_replication"
+
+var positionKey = []byte("position")
+
+// OpenFollower opens the follower environment in the directory path,
+// creating it if needed.
+func OpenFollower(path string, opts *FollowerOptions) (*Follower, error) {
+	f := &Follower{path: path}
+	if opts != nil {
+		f.opts = *opts
+	}
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the environment and reads the position.
+func (f *Follower) open() error {
+	env, err := mdb.NewEnv()
+	if err != nil {
+		return err
+	}
+	err = env.SetMaxDBs(f.opts.MaxDBs)
+	if err == nil && f.opts.MapSize > 0 {
+		err = env.SetMapSize(f.opts.MapSize)
+	}
+	if err == nil {
+		err = env.Open(f.path, 0, 0644)
+	}
+	if err == nil {
+		// Views open the databases while change sets are applied.
+		err = env.OpenNamedDBs()
+	}
+	if err != nil {
+		env.Close()
+		return err
+	}
+	var pos uint64
+	bootstrapped := false
+	err = env.View(func(txn *mdb.Txn) error {
+		name := replDBName
+		dbi, err := txn.DBIOpen(&name, 0)
+		if err == mdb.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		val, err := txn.Get(dbi, positionKey)
+		if err != nil {
+			return err
+		}
+		if len(val) != 8 {
+			return errors.New("Invalid replication position")
+		}
+		pos = binary.BigEndian.Uint64(val)
+		bootstrapped = true
+		return nil
+	})
+	if err != nil {
+		env.Close()
+		return err
+	}
+	f.env = env
+	f.statusMu.Lock()
+	f.status.Position = pos
+	f.bootstrapped = bootstrapped
+	f.statusMu.Unlock()
+	return nil
+}
+
+// View runs op in a read-only transaction of the follower environment. The
+// environment is not replaced by a new snapshot while op runs, but database
+// handles must not be kept across calls.
+func (f *Follower) View(op mdb.TxnOp) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.env == nil {
+		return errors.New("Follower closed")
+	}
+	return f.env.View(op)
+}
+
+// Status returns the replication status of the follower.
+func (f *Follower) Status() Status {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+	return f.status
+}
+
+// Close closes the follower environment. Run must have returned.
+func (f *Follower) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.env == nil {
+		return errors.New("Follower closed")
+	}
+	err := f.env.Close()
+	f.env = nil
+	return err
+}
+
+// Run replicates from the primary connected with conn until ctx is done or
+// the connection fails. It may be called again with a new connection to
+// resume replication, but not concurrently.
+func (f *Follower) Run(ctx context.Context, conn io.ReadWriter) error {
+	defer closeOnDone(ctx, conn)()
+	fr := newFrameReader(conn)
+	fw := newFrameWriter(conn)
+	status := f.Status()
+	err := writeHello(fw, status.Position, f.isBootstrapped())
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	var snapshot *os.File
+	defer func() {
+		if snapshot != nil {
+			snapshot.Close()
+			os.Remove(snapshot.Name())
+		}
+	}()
+	for {
+		typ, payload, err := fr.read()
+		if err != nil {
+			return ctxErr(ctx, err)
+		}
+		switch typ {
+		case frameSnapshot:
+			if snapshot == nil {
+				snapshot, err = os.Create(filepath.Join(f.path, "data.mdb.snapshot"))
+				if err != nil {
+					return err
+				}
+			}
+			_, err = snapshot.Write(payload)
+		case frameSnapshotEnd:
+			if snapshot == nil {
+				return errors.New("Empty snapshot")
+			}
+			err = f.restore(snapshot)
+			snapshot = nil
+			if err == nil {
+				err = writeHello(fw, f.Status().Position, true)
+			}
+		case frameChanges:
+			var cs mdb.ChangeSet
+			err = cs.UnmarshalBinary(payload)
+			if err == nil {
+				err = f.apply(&cs)
+			}
+		case frameHeartbeat:
+			var last uint64
+			last, err = uvarint(payload)
+			if err == nil {
+				f.statusMu.Lock()
+				f.status.Primary = last
+				f.statusMu.Unlock()
+			}
+		default:
+			err = errors.New("Unexpected frame")
+		}
+		if err != nil {
+			return ctxErr(ctx, err)
+		}
+	}
+}
+
+func (f *Follower) isBootstrapped() bool {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+	return f.bootstrapped
+}
+
+// restore replaces the environment with the snapshot and records the ID of
+// the last change set of the snapshot as the position.
+func (f *Follower) restore(snapshot *os.File) error {
+	err := snapshot.Sync()
+	if err == nil {
+		err = snapshot.Close()
+	}
+	if err != nil {
+		os.Remove(snapshot.Name())
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.env != nil {
+		f.env.Close()
+		f.env = nil
+	}
+	err = os.Rename(snapshot.Name(), filepath.Join(f.path, "data.mdb"))
+	if err != nil {
+		return err
+	}
+	err = f.open()
+	if err != nil {
+		return err
+	}
+	var pos uint64
+	err = f.env.Update(func(txn *mdb.Txn) (err error) {
+		pos, err = txn.LastChange()
+		if err != nil {
+			return err
+		}
+		return setPosition(txn, pos)
+	})
+	if err != nil {
+		return err
+	}
+	f.statusMu.Lock()
+	f.status.Position = pos
+	f.status.Applied = time.Now()
+	f.bootstrapped = true
+	f.statusMu.Unlock()
+	return nil
+}
+
+func setPosition(txn *mdb.Txn, pos uint64) error {
+	name := replDBName
+	dbi, err := txn.DBIOpen(&name, mdb.CREATE)
+	if err != nil {
+		return err
+	}
+	var val [8]byte
+	binary.BigEndian.PutUint64(val[:], pos)
+	return txn.Put(dbi, positionKey, val[:], 0)
+}
+
+// apply applies cs unless it was applied before.
+func (f *Follower) apply(cs *mdb.ChangeSet) error {
+	if cs.TxnID <= f.Status().Position {
+		return nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.env == nil {
+		return errors.New("Follower closed")
+	}
+	err := f.env.Update(func(txn *mdb.Txn) error {
+		err := applyChanges(txn, cs)
+		if err != nil {
+			return err
+		}
+		return setPosition(txn, cs.TxnID)
+	})
+	if err != nil {
+		return err
+	}
+	f.statusMu.Lock()
+	f.status.Position = cs.TxnID
+	if f.status.Primary < cs.TxnID {
+		f.status.Primary = cs.TxnID
+	}
+	f.status.Applied = time.Now()
+	f.statusMu.Unlock()
+	return nil
+}
+
+func applyChanges(txn *mdb.Txn, cs *mdb.ChangeSet) error {
+	dbs := map[string]mdb.DBI{}
+	for _, c := range cs.Changes {
+		dbi, ok := dbs[c.DB]
+		if !ok {
+			var err error
+			if c.DB == "" {
+				dbi, err = txn.DBIOpen(nil, c.Flags)
+			} else {
+				name := c.DB
+				dbi, err = txn.DBIOpen(&name, c.Flags|mdb.CREATE)
+			}
+			if err != nil {
+				return err
+			}
+			dbs[c.DB] = dbi
+		}
+		var err error
+		switch c.Op {
+		case mdb.ChangePut:
+			err = txn.Put(dbi, c.Key, c.Val, 0)
+		case mdb.ChangeDel:
+			err = txn.Del(dbi, c.Key, c.Val)
+		case mdb.ChangeEmpty:
+			err = txn.Drop(dbi, 0)
+		case mdb.ChangeDrop:
+			err = txn.Drop(dbi, 1)
+			delete(dbs, c.DB)
+		default:
+			err = errors.New("Unknown change operation")
+		}
+		if err != nil && err != mdb.NotFound {
+			return err
+		}
+	}
+	return nil
+}
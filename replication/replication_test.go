@@ -0,0 +1,199 @@
+package replication
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	mdb "github.com/szferi/gomdb"
+)
+
+func openPrimary(t *testing.T) (*mdb.Env, string) {
+	env, err := mdb.NewEnv()
+	if err != nil {
+		t.Fatalf("Cannot create environment: %s", err)
+	}
+	err = env.SetMaxDBs(16)
+	if err != nil {
+		t.Fatalf("Cannot set maxdbs: %s", err)
+	}
+	path, err := ioutil.TempDir("/tmp", "mdb_test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %s", err)
+	}
+	err = env.Open(path, 0, 0664)
+	if err != nil {
+		t.Fatalf("Cannot open environment: %s", err)
+	}
+	return env, path
+}
+
+func put(t *testing.T, env *mdb.Env, key, val string) {
+	err := env.Update(func(txn *mdb.Txn) error {
+		name := "users"
+		dbi, err := txn.DBIOpen(&name, mdb.CREATE)
+		if err != nil {
+			return err
+		}
+		if val == "" {
+			return txn.Del(dbi, []byte(key), nil)
+		}
+		return txn.Put(dbi, []byte(key), []byte(val), 0)
+	})
+	if err != nil {
+		t.Fatalf("Cannot update primary: %s", err)
+	}
+}
+
+func get(f *Follower, key string) (string, error) {
+	var val []byte
+	err := f.View(func(txn *mdb.Txn) error {
+		name := "users"
+		dbi, err := txn.DBIOpen(&name, 0)
+		if err != nil {
+			return err
+		}
+		val, err = txn.Get(dbi, []byte(key))
+		return err
+	})
+	return string(val), err
+}
+
+func lastChange(t *testing.T, env *mdb.Env) uint64 {
+	var last uint64
+	err := env.View(func(txn *mdb.Txn) (err error) {
+		last, err = txn.LastChange()
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Cannot get last change: %s", err)
+	}
+	return last
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type session struct {
+	cancel  context.CancelFunc
+	primary chan error
+	run     chan error
+}
+
+func connect(p *Primary, f *Follower) *session {
+	ctx, cancel := context.WithCancel(context.Background())
+	a, b := net.Pipe()
+	s := &session{cancel, make(chan error, 1), make(chan error, 1)}
+	go func() { s.primary <- p.Serve(ctx, a) }()
+	go func() { s.run <- f.Run(ctx, b) }()
+	return s
+}
+
+func (s *session) stop(t *testing.T) {
+	s.cancel()
+	for _, ch := range []chan error{s.primary, s.run} {
+		err := <-ch
+		if err != context.Canceled {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	env, path := openPrimary(t)
+	defer os.RemoveAll(path)
+	defer env.Close()
+	p := NewPrimary(env, &PrimaryOptions{Heartbeat: 10 * time.Millisecond})
+	put(t, env, "alice", "1")
+	put(t, env, "bob", "2")
+
+	fpath, err := ioutil.TempDir("/tmp", "mdb_test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(fpath)
+	f, err := OpenFollower(fpath, &FollowerOptions{MaxDBs: 16})
+	if err != nil {
+		t.Fatalf("Cannot open follower: %s", err)
+	}
+	defer f.Close()
+
+	s := connect(p, f)
+	waitFor(t, "bootstrap", func() bool {
+		val, _ := get(f, "bob")
+		return val == "2"
+	})
+	put(t, env, "carol", "3")
+	put(t, env, "alice", "")
+	last := lastChange(t, env)
+	waitFor(t, "lag 0", func() bool {
+		status := f.Status()
+		return status.Position == last && status.Lag() == 0
+	})
+	val, err := get(f, "carol")
+	if err != nil || val != "3" {
+		t.Errorf("carol not replicated: %q, %v", val, err)
+	}
+	_, err = get(f, "alice")
+	if err != mdb.NotFound {
+		t.Errorf("alice not deleted: %v", err)
+	}
+	s.stop(t)
+
+	put(t, env, "dave", "4")
+	status := f.Status()
+	if status.Position != last {
+		t.Errorf("Position %d want %d", status.Position, last)
+	}
+	s = connect(p, f)
+	waitFor(t, "changes after reconnect", func() bool {
+		val, _ := get(f, "dave")
+		return val == "4"
+	})
+	s.stop(t)
+	if f.Status().Position <= last {
+		t.Errorf("Position not advanced: %d", f.Status().Position)
+	}
+}
+
+func TestApplyTwice(t *testing.T) {
+	fpath, err := ioutil.TempDir("/tmp", "mdb_test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(fpath)
+	f, err := OpenFollower(fpath, &FollowerOptions{MaxDBs: 4})
+	if err != nil {
+		t.Fatalf("Cannot open follower: %s", err)
+	}
+	defer f.Close()
+	put := &mdb.ChangeSet{TxnID: 5, Changes: []mdb.Change{
+		{Op: mdb.ChangePut, DB: "users", Key: []byte("alice"), Val: []byte("1")},
+	}}
+	del := &mdb.ChangeSet{TxnID: 6, Changes: []mdb.Change{
+		{Op: mdb.ChangeDel, DB: "users", Key: []byte("alice")},
+	}}
+	for _, cs := range []*mdb.ChangeSet{put, del, put} {
+		err = f.apply(cs)
+		if err != nil {
+			t.Fatalf("Cannot apply change set: %s", err)
+		}
+	}
+	_, err = get(f, "alice")
+	if err != mdb.NotFound {
+		t.Errorf("Change set applied twice: %v", err)
+	}
+	if f.Status().Position != 6 {
+		t.Errorf("Position %d want 6", f.Status().Position)
+	}
+}
@@ -0,0 +1,139 @@
+package mdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// metaDBName is the name of the database holding the metadata of the
+// library, such as sequences. It counts against the limit set by SetMaxDBs.
+const metaDBName = "__gomdb_meta"
+
+// openMeta returns the handle of the metadata database. If create is false
+// and the database does not exist yet NotFound is returned.
+func (txn *Txn) openMeta(create bool) (DBI, error) {
+	return txn.internalDBI(metaDBName, create)
+}
+
+// getMeta returns the value of key in the metadata database, or nil if
+// there is none.
+func getMeta(txn *Txn, key []byte) ([]byte, error) {
+	meta, err := txn.openMeta(false)
+	if err == NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	val, err := txn.Get(meta, key)
+	if err == NotFound {
+		return nil, nil
+	}
+	return val, err
+}
+
+func sequenceKey(name string) []byte {
+	return []byte("seq:" + name)
+}
+
+// Sequence returns the current value of the sequence called name. A sequence
+// that was never advanced is 0.
+func (txn *Txn) Sequence(name string) (uint64, error) {
+	val, err := getMeta(txn, sequenceKey(name))
+	if err != nil || val == nil {
+		return 0, err
+	}
+	if len(val) != 8 {
+		return 0, errors.New("Invalid sequence value")
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// SetSequence sets the current value of the sequence called name.
+func (txn *Txn) SetSequence(name string, v uint64) error {
+	meta, err := txn.openMeta(true)
+	if err != nil {
+		return err
+	}
+	var val [8]byte
+	binary.BigEndian.PutUint64(val[:], v)
+	return txn.Put(meta, sequenceKey(name), val[:], 0)
+}
+
+// ReserveSequence advances the sequence called name by n and returns the
+// first of the n reserved values. Like all writes the reservation is undone
+// if the transaction is aborted.
+func (txn *Txn) ReserveSequence(name string, n uint64) (uint64, error) {
+	if n == 0 {
+		return 0, errors.New("Cannot reserve an empty range")
+	}
+	v, err := txn.Sequence(name)
+	if err != nil {
+		return 0, err
+	}
+	if v+n < v {
+		return 0, errors.New("Sequence overflow")
+	}
+	err = txn.SetSequence(name, v+n)
+	if err != nil {
+		return 0, err
+	}
+	return v + 1, nil
+}
+
+// NextSequence advances the sequence of the database dbi and returns its new
+// value. The first value is 1. The sequence is named after the database, so
+// it is shared by all handles of the database.
+func (txn *Txn) NextSequence(dbi DBI) (uint64, error) {
+	name, ok := txn.dbiName(dbi)
+	if !ok {
+		return 0, errors.New("Unknown DBI")
+	}
+	return txn.ReserveSequence(name, 1)
+}
+
+// SequenceLease hands out values of a sequence from blocks reserved in
+// separate write transactions, so inserters do not update the sequence for
+// every value. Values of a block that are not handed out before the lease is
+// dropped are lost, and values are not returned when the transaction using
+// them aborts.
+type SequenceLease struct {
+	env  *Env
+	name string
+	size uint64
+	mu   sync.Mutex
+	next uint64
+	last uint64
+}
+
+// NewSequenceLease returns a lease on the sequence called name reserving
+// size values at a time.
+func (env *Env) NewSequenceLease(name string, size uint64) *SequenceLease {
+	if size == 0 {
+		size = 1
+	}
+	return &SequenceLease{env: env, name: name, size: size}
+}
+
+// Next returns the next value of the lease. It reserves a new block with
+// Env.Update when the current one is used up and must therefore not be
+// called from within a write transaction of the same environment.
+func (l *SequenceLease) Next() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.next == 0 || l.next > l.last {
+		var first uint64
+		err := l.env.Update(func(txn *Txn) (err error) {
+			first, err = txn.ReserveSequence(l.name, l.size)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		l.next, l.last = first, first+l.size-1
+	}
+	v := l.next
+	l.next++
+	return v, nil
+}
@@ -0,0 +1,276 @@
+package mdb
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSequence(t *testing.T) {
+	env := setupMaxDBs(t, 2)
+	defer clean(env, t)
+
+	err := env.View(func(txn *Txn) error {
+		v, err := txn.Sequence("items")
+		if err != nil || v != 0 {
+			t.Errorf("Sequence before first use: %d, %v", v, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+
+	name := "items"
+	var dbi DBI
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.DBIOpen(&name, CREATE)
+		if err != nil {
+			return err
+		}
+		for i := uint64(1); i <= 3; i++ {
+			v, err := txn.NextSequence(dbi)
+			if err != nil {
+				return err
+			}
+			if v != i {
+				t.Errorf("NextSequence returned %d, expected %d", v, i)
+			}
+		}
+		first, err := txn.ReserveSequence(name, 10)
+		if err != nil {
+			return err
+		}
+		if first != 4 {
+			t.Errorf("ReserveSequence returned %d, expected 4", first)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+
+	errFail := errors.New("fail")
+	err = env.Update(func(txn *Txn) error {
+		_, err := txn.NextSequence(dbi)
+		if err != nil {
+			return err
+		}
+		return errFail
+	})
+	if err != errFail {
+		t.Fatalf("Update returned %v, expected %v", err, errFail)
+	}
+	err = env.View(func(txn *Txn) error {
+		v, err := txn.Sequence(name)
+		if err != nil {
+			return err
+		}
+		if v != 13 {
+			t.Errorf("Sequence not rolled back: %d", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+
+	path, err := ioutil.TempDir("/tmp", "mdb_test")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory")
+	}
+	defer os.RemoveAll(path)
+	err = env.Copy(path)
+	if err != nil {
+		t.Fatalf("Cannot copy environment: %s", err)
+	}
+	cenv, err := NewEnv()
+	if err != nil {
+		t.Fatalf("Cannot create environment: %s", err)
+	}
+	defer cenv.Close()
+	err = cenv.SetMaxDBs(2)
+	if err != nil {
+		t.Fatalf("Cannot set maxdbs: %s", err)
+	}
+	err = cenv.Open(path, 0, 0664)
+	if err != nil {
+		t.Fatalf("Cannot open copy: %s", err)
+	}
+	err = cenv.View(func(txn *Txn) error {
+		v, err := txn.Sequence(name)
+		if err != nil {
+			return err
+		}
+		if v != 13 {
+			t.Errorf("Sequence of the copy is %d", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View copy: %s", err)
+	}
+}
+
+func TestSequenceLease(t *testing.T) {
+	env := setupMaxDBs(t, 1)
+	defer clean(env, t)
+
+	a := env.NewSequenceLease("ids", 4)
+	b := env.NewSequenceLease("ids", 4)
+	seen := map[uint64]bool{}
+	for i := 0; i < 10; i++ {
+		for _, l := range []*SequenceLease{a, b} {
+			v, err := l.Next()
+			if err != nil {
+				t.Fatalf("Cannot get next value: %s", err)
+			}
+			if seen[v] {
+				t.Errorf("Value %d handed out twice", v)
+			}
+			seen[v] = true
+		}
+	}
+	err := env.View(func(txn *Txn) error {
+		v, err := txn.Sequence("ids")
+		if err != nil {
+			return err
+		}
+		if v != 24 {
+			t.Errorf("Unexpected sequence after leasing: %d", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestSequenceReadTxn(t *testing.T) {
+	env := setupMaxDBs(t, 2)
+	path, err := env.Path()
+	if err != nil {
+		t.Fatalf("Cannot get path: %s", err)
+	}
+	defer os.RemoveAll(path)
+	// A read transaction begun before the metadata database is created
+	// does not see it.
+	old, err := env.BeginTxn(nil, RDONLY)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction: %s", err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		return txn.SetSequence("x", 1)
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+	v, err := old.Sequence("x")
+	if err != nil || v != 0 {
+		t.Errorf("Sequence of an older snapshot: %d, %v", v, err)
+	}
+	old.Abort()
+	env.Close()
+
+	// The handle of the metadata database must outlive read transactions
+	// using it, or a writer using the same handle fails to commit.
+	env, err = NewEnv()
+	if err != nil {
+		t.Fatalf("Cannot create environment: %s", err)
+	}
+	defer env.Close()
+	err = env.SetMaxDBs(2)
+	if err != nil {
+		t.Fatalf("Cannot set maxdbs: %s", err)
+	}
+	err = env.Open(path, 0, 0664)
+	if err != nil {
+		t.Fatalf("Cannot open environment: %s", err)
+	}
+	rtxn, err := env.BeginTxn(nil, RDONLY)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction: %s", err)
+	}
+	v, err = rtxn.Sequence("x")
+	if err != nil || v != 1 {
+		t.Errorf("Sequence: %d, %v", v, err)
+	}
+	wtxn, err := env.BeginTxn(nil, 0)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction: %s", err)
+	}
+	err = wtxn.SetSequence("x", 42)
+	if err != nil {
+		t.Fatalf("Cannot set sequence: %s", err)
+	}
+	rtxn.Abort()
+	err = wtxn.Commit()
+	if err != nil {
+		t.Fatalf("Cannot commit: %s", err)
+	}
+	err = env.View(func(txn *Txn) error {
+		v, err := txn.Sequence("x")
+		if err == nil && v != 42 {
+			t.Errorf("Sequence is %d", v)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestSequenceLazyOpen(t *testing.T) {
+	env := setupMaxDBs(t, 2)
+	path, err := env.Path()
+	if err != nil {
+		t.Fatalf("Cannot get path: %s", err)
+	}
+	defer os.RemoveAll(path)
+	err = env.Update(func(txn *Txn) error {
+		return txn.SetSequence("x", 7)
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+	env.Close()
+
+	env, err = NewEnv()
+	if err != nil {
+		t.Fatalf("Cannot create environment: %s", err)
+	}
+	defer env.Close()
+	err = env.SetMaxDBs(2)
+	if err != nil {
+		t.Fatalf("Cannot set maxdbs: %s", err)
+	}
+	// Open neither waits for writers nor opens the internal databases.
+	env.writer <- struct{}{}
+	err = env.Open(path, 0, 0664)
+	if err != nil {
+		t.Fatalf("Cannot open environment: %s", err)
+	}
+	if len(env.internal) != 0 {
+		t.Errorf("Internal databases opened by Open: %v", env.internal)
+	}
+	// A reader beginning while a writer runs does not wait for it.
+	err = env.View(func(txn *Txn) error {
+		_, err := txn.Sequence("x")
+		return err
+	})
+	if err == nil {
+		t.Errorf("Sequence read without an open handle")
+	}
+	<-env.writer
+	err = env.View(func(txn *Txn) error {
+		v, err := txn.Sequence("x")
+		if err == nil && v != 7 {
+			t.Errorf("Sequence is %d", v)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
@@ -0,0 +1,286 @@
+package mdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ExpiringBucket is a database whose entries can expire. Each value is
+// stored behind its expiry time, and entries with an expiry time are also
+// recorded in a second database named "<name>.expiry" ordered by that time,
+// which lets Sweep find expired entries without scanning the bucket.
+// Expired entries are hidden by Get until they are deleted by Sweep or a
+// sweeper started with Env.StartSweeper.
+//
+// Entries of the bucket must only be written through the bucket. As the
+// keys of the expiry database are the keys of the bucket behind an 8-byte
+// expiry time, keys are limited to Env.MaxKeySize()-8 bytes.
+type ExpiringBucket struct {
+	dbi    DBI
+	expiry DBI
+	now    func() time.Time
+}
+
+// OpenExpiringBucket opens the named database name as an ExpiringBucket.
+// flags are passed to DBIOpen; DUPSORT is not allowed.
+func OpenExpiringBucket(txn *Txn, name string, flags uint) (*ExpiringBucket, error) {
+	if flags&DUPSORT != 0 {
+		return nil, errors.New("Expiring bucket must not be DUPSORT")
+	}
+	dbi, err := txn.DBIOpen(&name, flags)
+	if err != nil {
+		return nil, err
+	}
+	expiryName := name + ".expiry"
+	expiry, err := txn.DBIOpen(&expiryName, flags&CREATE)
+	if err != nil {
+		return nil, err
+	}
+	return &ExpiringBucket{dbi: dbi, expiry: expiry, now: time.Now}, nil
+}
+
+// DBI returns the handle of the database holding the entries.
+func (b *ExpiringBucket) DBI() DBI {
+	return b.dbi
+}
+
+// Get returns the value of key. Expired entries are reported as NotFound.
+func (b *ExpiringBucket) Get(txn *Txn, key []byte) ([]byte, error) {
+	ts, val, err := b.get(txn, key)
+	if err != nil {
+		return nil, err
+	}
+	if ts != 0 && ts <= uint64(b.now().UnixNano()) {
+		return nil, NotFound
+	}
+	return val, nil
+}
+
+// ExpiresAt returns the time key expires, or the zero time if it does not
+// expire.
+func (b *ExpiringBucket) ExpiresAt(txn *Txn, key []byte) (time.Time, error) {
+	ts, _, err := b.get(txn, key)
+	if err != nil || ts == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(ts)), nil
+}
+
+// Put stores val for key. The entry expires after ttl; if ttl is not
+// positive it never expires. Keys longer than Env.MaxKeySize()-8 bytes are
+// rejected.
+func (b *ExpiringBucket) Put(txn *Txn, key, val []byte, ttl time.Duration) error {
+	if max := txn.env.MaxKeySize() - 8; len(key) > max {
+		return fmt.Errorf("Key of %d bytes exceeds the %d bytes allowed in an expiring bucket", len(key), max)
+	}
+	err := b.Del(txn, key)
+	if err != nil && err != NotFound {
+		return err
+	}
+	var ts uint64
+	if ttl > 0 {
+		ts = uint64(b.now().Add(ttl).UnixNano())
+		err = txn.Put(b.expiry, expiryKey(ts, key), nil, 0)
+		if err != nil {
+			return err
+		}
+	}
+	data := make([]byte, 8+len(val))
+	binary.BigEndian.PutUint64(data, ts)
+	copy(data[8:], val)
+	return txn.Put(b.dbi, key, data, 0)
+}
+
+// Del removes key, whether it has expired or not.
+func (b *ExpiringBucket) Del(txn *Txn, key []byte) error {
+	ts, _, err := b.get(txn, key)
+	if err != nil {
+		return err
+	}
+	if ts != 0 {
+		err = txn.Del(b.expiry, expiryKey(ts, key), nil)
+		if err != nil && err != NotFound {
+			return err
+		}
+	}
+	return txn.Del(b.dbi, key, nil)
+}
+
+// Sweep deletes up to max entries that expired at or before now and returns
+// the number of deleted entries. If max is not positive all expired entries
+// are deleted.
+func (b *ExpiringBucket) Sweep(txn *Txn, now time.Time, max int) (int, error) {
+	cursor, err := txn.CursorOpen(b.expiry)
+	if err != nil {
+		return 0, err
+	}
+	limit := uint64(now.UnixNano())
+	var expired [][]byte
+	k, _, err := cursor.Get(nil, nil, FIRST)
+	for err == nil && (max <= 0 || len(expired) < max) {
+		if len(k) < 8 || binary.BigEndian.Uint64(k) > limit {
+			break
+		}
+		expired = append(expired, k)
+		k, _, err = cursor.Get(nil, nil, NEXT)
+	}
+	cursor.Close()
+	if err != nil && err != NotFound {
+		return 0, err
+	}
+	for _, k := range expired {
+		err = txn.Del(b.expiry, k, nil)
+		if err != nil {
+			return 0, err
+		}
+		err = txn.Del(b.dbi, k[8:], nil)
+		if err != nil && err != NotFound {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
+
+func (b *ExpiringBucket) get(txn *Txn, key []byte) (uint64, []byte, error) {
+	data, err := txn.Get(b.dbi, key)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < 8 {
+		return 0, nil, errors.New("Invalid expiring bucket entry")
+	}
+	return binary.BigEndian.Uint64(data), data[8:], nil
+}
+
+func expiryKey(ts uint64, key []byte) []byte {
+	k := make([]byte, 8+len(key))
+	binary.BigEndian.PutUint64(k, ts)
+	copy(k[8:], key)
+	return k
+}
+
+// SweepOptions controls a sweeper started with Env.StartSweeper.
+type SweepOptions struct {
+	BatchSize int           // Maximum number of entries deleted per transaction.
+	Interval  time.Duration // Time between sweeps.
+	OnError   func(error)   // Called with the errors of sweeps if not nil.
+}
+
+// DefaultSweepOptions are used for the zero fields of SweepOptions.
+var DefaultSweepOptions = SweepOptions{BatchSize: 1000, Interval: time.Minute}
+
+type sweeper struct {
+	bucket *ExpiringBucket
+	opts   SweepOptions
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// StartSweeper starts a goroutine deleting the expired entries of b every
+// opts.Interval. Each sweep deletes at most opts.BatchSize entries per write
+// transaction and continues with new transactions until no expired entries
+// are left. The sweeper runs until StopSweeper or Close is called.
+func (env *Env) StartSweeper(b *ExpiringBucket, opts SweepOptions) error {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultSweepOptions.BatchSize
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultSweepOptions.Interval
+	}
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.closing {
+		return errors.New("Environment closed")
+	}
+	if env.sweepers[b] != nil {
+		return errors.New("Sweeper already running")
+	}
+	if env.sweepers == nil {
+		env.sweepers = map[*ExpiringBucket]*sweeper{}
+	}
+	s := &sweeper{
+		bucket: b,
+		opts:   opts,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	env.sweepers[b] = s
+	go s.loop(env)
+	return nil
+}
+
+// StopSweeper stops the sweeper of b and waits for a running sweep to
+// finish.
+func (env *Env) StopSweeper(b *ExpiringBucket) {
+	env.mu.Lock()
+	s := env.sweepers[b]
+	delete(env.sweepers, b)
+	env.mu.Unlock()
+	if s != nil {
+		close(s.stop)
+		<-s.done
+	}
+}
+
+// stopSweepers stops all sweepers. It must be called after stopBatcher, which
+// prevents new sweepers from being started.
+func (env *Env) stopSweepers() {
+	env.mu.Lock()
+	sweepers := env.sweepers
+	env.sweepers = nil
+	env.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, s := range sweepers {
+		close(s.stop)
+		wg.Add(1)
+		go func(s *sweeper) {
+			<-s.done
+			wg.Done()
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (s *sweeper) loop(env *Env) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.sweep(env)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// sweep deletes expired entries in transactions of at most BatchSize
+// deletions until none are left or the sweeper is stopped.
+func (s *sweeper) sweep(env *Env) {
+	now := s.bucket.now()
+	for {
+		var n int
+		err := env.Update(func(txn *Txn) (err error) {
+			n, err = s.bucket.Sweep(txn, now, s.opts.BatchSize)
+			return err
+		})
+		if err != nil {
+			if s.opts.OnError != nil {
+				s.opts.OnError(err)
+			}
+			return
+		}
+		if n < s.opts.BatchSize {
+			return
+		}
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package mdb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpiringBucket(t *testing.T) {
+	env := setupMaxDBs(t, 2)
+	defer clean(env, t)
+
+	now := time.Unix(1000, 0)
+	err := env.Update(func(txn *Txn) error {
+		b, err := OpenExpiringBucket(txn, "cache", CREATE)
+		if err != nil {
+			return err
+		}
+		b.now = func() time.Time { return now }
+		err = b.Put(txn, []byte("a"), []byte("1"), time.Second)
+		if err != nil {
+			return err
+		}
+		err = b.Put(txn, []byte("b"), []byte("2"), 3*time.Second)
+		if err != nil {
+			return err
+		}
+		err = b.Put(txn, []byte("c"), []byte("3"), 0)
+		if err != nil {
+			return err
+		}
+		// Extending the TTL replaces the expiry index entry.
+		err = b.Put(txn, []byte("a"), []byte("4"), 2*time.Second)
+		if err != nil {
+			return err
+		}
+		at, err := b.ExpiresAt(txn, []byte("a"))
+		if err != nil || !at.Equal(now.Add(2*time.Second)) {
+			t.Errorf("ExpiresAt: %v, %v", at, err)
+		}
+
+		now = now.Add(2 * time.Second)
+		if _, err = b.Get(txn, []byte("a")); err != NotFound {
+			t.Errorf("Expired entry returned: %v", err)
+		}
+		if val, err := b.Get(txn, []byte("b")); err != nil || string(val) != "2" {
+			t.Errorf("Get(b): %q, %v", val, err)
+		}
+		n, err := b.Sweep(txn, now, 0)
+		if err != nil {
+			return err
+		}
+		if n != 1 {
+			t.Errorf("Sweep deleted %d entries", n)
+		}
+		if _, err = txn.Get(b.DBI(), []byte("a")); err != NotFound {
+			t.Errorf("Swept entry still stored: %v", err)
+		}
+
+		now = now.Add(time.Hour)
+		n, err = b.Sweep(txn, now, 0)
+		if err != nil {
+			return err
+		}
+		if n != 1 {
+			t.Errorf("Second sweep deleted %d entries", n)
+		}
+		if val, err := b.Get(txn, []byte("c")); err != nil || string(val) != "3" {
+			t.Errorf("Entry without TTL: %q, %v", val, err)
+		}
+		stat, err := txn.Stat(b.expiry)
+		if err != nil {
+			return err
+		}
+		if stat.Entries != 0 {
+			t.Errorf("Expiry index not empty: %d", stat.Entries)
+		}
+
+		// The expiry time takes 8 bytes of the keys of the expiry index.
+		long := bytes.Repeat([]byte{'k'}, env.MaxKeySize()-8)
+		err = b.Put(txn, long, []byte("5"), time.Second)
+		if err != nil {
+			t.Errorf("Put with the longest key: %s", err)
+		}
+		err = b.Put(txn, append(long, 'k'), []byte("6"), time.Second)
+		if err == nil || !strings.Contains(err.Error(), "exceeds") {
+			t.Errorf("Put with a too long key: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	env := setupMaxDBs(t, 2)
+	defer clean(env, t)
+
+	var b *ExpiringBucket
+	err := env.Update(func(txn *Txn) (err error) {
+		b, err = OpenExpiringBucket(txn, "cache", CREATE)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 25; i++ {
+			err = b.Put(txn, []byte(fmt.Sprintf("key-%02d", i)), nil, time.Millisecond)
+			if err != nil {
+				return err
+			}
+		}
+		return b.Put(txn, []byte("keep"), nil, time.Hour)
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	err = env.StartSweeper(b, SweepOptions{BatchSize: 10, Interval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Cannot start sweeper: %s", err)
+	}
+	if env.StartSweeper(b, SweepOptions{}) == nil {
+		t.Errorf("Second sweeper started for the same bucket")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var entries uint64
+		err = env.View(func(txn *Txn) error {
+			stat, err := txn.Stat(b.DBI())
+			if err != nil {
+				return err
+			}
+			entries = stat.Entries
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("View: %s", err)
+		}
+		if entries == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Sweeper left %d entries", entries)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Close stops the sweeper.
+}
@@ -8,12 +8,36 @@ package mdb
 #include <stdlib.h>
 #include <stdio.h>
 #include "lmdb.h"
+
+// Call mdb_get for n keys. The result of each call is stored in rcs.
+static void gomdb_get_many(MDB_txn *txn, MDB_dbi dbi, MDB_val *keys, MDB_val *vals, int *rcs, size_t n) {
+	size_t i;
+	for (i = 0; i < n; i++)
+		rcs[i] = mdb_get(txn, dbi, &keys[i], &vals[i]);
+}
+
+// Call mdb_put for n items, stopping at the first failure. The number of
+// items put is returned and the result of the last call is stored in rc.
+static size_t gomdb_put_many(MDB_txn *txn, MDB_dbi dbi, MDB_val *keys, MDB_val *vals, unsigned int flags, size_t n, int *rc) {
+	size_t i;
+	*rc = MDB_SUCCESS;
+	for (i = 0; i < n; i++) {
+		*rc = mdb_put(txn, dbi, &keys[i], &vals[i], flags);
+		if (*rc != MDB_SUCCESS)
+			break;
+	}
+	return i;
+}
 */
 import "C"
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"math"
 	"runtime"
+	"sync"
 	"unsafe"
 )
 
@@ -41,35 +65,206 @@ const (
 // All database operations require a transaction handle.
 // Transactions may be read-only or read-write.
 type Txn struct {
-	_txn *C.MDB_txn
+	_txn    *C.MDB_txn
+	env     *Env
+	parent  *Txn
+	rdonly  bool
+	ctx     context.Context // checked by iterators, may be nil
+	writer  chan struct{}   // write lock of the Env held by the transaction
+	reading bool            // a read-only transaction counted by Env.readers
+	changes *txnChanges     // writes recorded for the changelog, may be nil
+	dbis    map[string]DBI  // internal databases opened by the transaction
 }
 
 func (env *Env) BeginTxn(parent *Txn, flags uint) (*Txn, error) {
+	return env.beginTxn(nil, parent, flags)
+}
+
+func (env *Env) beginTxn(ctx context.Context, parent *Txn, flags uint) (*Txn, error) {
+	var writer chan struct{}
+	if parent == nil && flags&RDONLY == 0 {
+		// Wait for other writers of this process in Go, where the wait
+		// can be canceled, instead of in mdb_txn_begin.
+		err := env.lockWriter(ctx)
+		if err != nil {
+			return nil, err
+		}
+		writer = env.writer
+	}
+	txn, err := env.beginLocked(ctx, parent, flags)
+	if err != nil {
+		if writer != nil {
+			<-writer
+		}
+		return nil, err
+	}
+	txn.writer = writer
+	return txn, nil
+}
+
+// beginLocked begins a transaction like beginTxn, but the write lock of the
+// Env must already be held for a top-level write transaction. It is not
+// released by the transaction.
+func (env *Env) beginLocked(ctx context.Context, parent *Txn, flags uint) (*Txn, error) {
 	var _txn *C.MDB_txn
 	var ptxn *C.MDB_txn
-	if parent == nil {
-		ptxn = nil
-	} else {
+	if parent != nil {
 		ptxn = parent._txn
+		if ctx == nil {
+			ctx = parent.ctx
+		}
+	}
+	if parent == nil && flags&RDONLY != 0 {
+		env.openInternalDBIs()
 	}
 	if flags&RDONLY == 0 {
 		runtime.LockOSThread()
+	} else {
+		env.addReaders(1)
 	}
 	ret := C.mdb_txn_begin(env._env, ptxn, C.uint(flags), &_txn)
 	if ret != SUCCESS {
 		runtime.UnlockOSThread()
+		if flags&RDONLY != 0 {
+			env.addReaders(-1)
+		}
 		return nil, errno(ret)
 	}
-	return &Txn{_txn}, nil
+	txn := &Txn{_txn: _txn, env: env, parent: parent, rdonly: flags&RDONLY != 0, reading: flags&RDONLY != 0, ctx: ctx}
+	if flags&RDONLY == 0 {
+		if parent != nil && parent.changes != nil {
+			txn.changes = &txnChanges{log: parent.changes.log, parent: parent.changes, dbs: map[DBI]*Change{}}
+		} else if log := env.getChangelog(); parent == nil && log != nil {
+			txn.changes = &txnChanges{log: log, dbs: map[DBI]*Change{}}
+		}
+	}
+	return txn, nil
+}
+
+func (env *Env) lockWriter(ctx context.Context) error {
+	if ctx == nil {
+		env.writer <- struct{}{}
+		return nil
+	}
+	select {
+	case env.writer <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// unlockWriter releases the write lock of the Env if txn holds it.
+func (txn *Txn) unlockWriter() {
+	if txn.writer != nil {
+		<-txn.writer
+		txn.writer = nil
+	}
+}
+
+// TxnOp is a function run inside a managed transaction by Env.Update and
+// Env.View. It must not commit or abort the transaction it is given.
+type TxnOp func(txn *Txn) error
+
+// Update runs op inside a read-write transaction. The transaction is
+// committed if op returns nil and aborted if op returns an error or panics,
+// in which case the panic is propagated after the abort. The OS thread locked
+// by BeginTxn is always released before Update returns.
+//
+// If a growth policy is set with SetGrowthPolicy and op or the commit fails
+// with MapFull, the transaction is aborted, the map is enlarged and op is run
+// again in a new transaction. The map is not enlarged while read-only
+// transactions of the process are active; MapFull is returned instead.
+func (env *Env) Update(op TxnOp) error {
+	return env.update(nil, op)
+}
+
+func (env *Env) update(ctx context.Context, op TxnOp) error {
+	// The write lock is held until op succeeds, so that no other writer
+	// of this process begins while the map is grown.
+	err := env.lockWriter(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { <-env.writer }()
+	for {
+		err := env.run(ctx, 0, op)
+		if err != MapFull {
+			return err
+		}
+		policy := env.growthPolicy()
+		if policy == nil {
+			return err
+		}
+		err = env.grow(policy)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// View runs op inside a read-only transaction which is always aborted when op
+// returns. Panics in op are propagated after the abort.
+func (env *Env) View(op TxnOp) error {
+	return env.run(nil, RDONLY, op)
+}
+
+// run runs op in a transaction. For a write transaction the write lock of
+// the Env must be held.
+func (env *Env) run(ctx context.Context, flags uint, op TxnOp) error {
+	txn, err := env.beginLocked(ctx, nil, flags)
+	if err == MapResized && env.adoptMapSize(flags&RDONLY == 0) {
+		// Another process grew the map and its size was adopted.
+		txn, err = env.beginLocked(ctx, nil, flags)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			txn.Abort()
+			panic(e)
+		}
+	}()
+	err = op(txn)
+	if err == nil && ctx != nil {
+		err = ctx.Err()
+	}
+	if err != nil || flags&RDONLY != 0 {
+		txn.Abort()
+		return err
+	}
+	return txn.Commit()
 }
 
 func (txn *Txn) Commit() error {
+	changes := txn.changes
+	cs, err := txn.logChanges()
+	if err != nil {
+		txn.Abort()
+		return err
+	}
+	// Readers that begin after the commit must find the handles it keeps.
+	keep := txn.dbis != nil && txn.parent == nil
+	if keep {
+		txn.env.internalMu.Lock()
+	}
 	ret := C.mdb_txn_commit(txn._txn)
 	runtime.UnlockOSThread()
-    // The transaction handle is freed if there was no error
-    if ret == C.MDB_SUCCESS {
-        txn._txn = nil
-    }
+	// The transaction handle is freed even if the commit failed.
+	txn._txn = nil
+	if ret == SUCCESS {
+		txn.keepDBIs()
+		changes.keepChanges()
+	}
+	if keep {
+		txn.env.internalMu.Unlock()
+	}
+	txn.unlockWriter()
+	txn.endRead()
+	if ret == SUCCESS && cs != nil {
+		changes.log.notify(cs)
+	}
 	return errno(ret)
 }
 
@@ -81,17 +276,35 @@ func (txn *Txn) Abort() {
 	runtime.UnlockOSThread()
     // The transaction handle is always freed.
 	txn._txn = nil
+	txn.unlockWriter()
+	txn.endRead()
 }
 
 func (txn *Txn) Reset() {
 	C.mdb_txn_reset(txn._txn)
+	txn.endRead()
 }
 
 func (txn *Txn) Renew() error {
+	if txn.env != nil && !txn.reading {
+		txn.env.addReaders(1)
+		txn.reading = true
+	}
 	ret := C.mdb_txn_renew(txn._txn)
+	if ret != SUCCESS {
+		txn.endRead()
+	}
 	return errno(ret)
 }
 
+// endRead stops counting txn as an active read-only transaction.
+func (txn *Txn) endRead() {
+	if txn.reading {
+		txn.reading = false
+		txn.env.addReaders(-1)
+	}
+}
+
 func (txn *Txn) DBIOpen(name *string, flags uint) (DBI, error) {
 	var _dbi C.MDB_dbi
 	var cname *C.char
@@ -105,9 +318,250 @@ func (txn *Txn) DBIOpen(name *string, flags uint) (DBI, error) {
 	if ret != SUCCESS {
 		return DBI(math.NaN()), errno(ret)
 	}
+	registerDBIName(C.mdb_txn_env(txn._txn), DBI(_dbi), name)
+	if name != nil && !txn.rdonly && txn.env != nil && isInternalDB(*name) {
+		if txn.dbis == nil {
+			txn.dbis = map[string]DBI{}
+		}
+		txn.dbis[*name] = DBI(_dbi)
+	}
 	return DBI(_dbi), nil
 }
 
+// The names of opened DBIs are recorded per environment because LMDB does
+// not return them. The main database has the empty name.
+type dbiKey struct {
+	env *C.MDB_env
+	dbi DBI
+}
+
+var dbiNames = struct {
+	sync.RWMutex
+	names map[dbiKey]string
+}{names: map[dbiKey]string{}}
+
+func registerDBIName(env *C.MDB_env, dbi DBI, name *string) {
+	var s string
+	if name != nil {
+		s = *name
+	}
+	dbiNames.Lock()
+	dbiNames.names[dbiKey{env, dbi}] = s
+	dbiNames.Unlock()
+}
+
+// releaseDBINames forgets the names of the DBIs of env. If all is false only
+// the name of dbi is forgotten.
+func releaseDBINames(env *C.MDB_env, dbi DBI, all bool) {
+	dbiNames.Lock()
+	defer dbiNames.Unlock()
+	for key := range dbiNames.names {
+		if key.env == env && (all || key.dbi == dbi) {
+			delete(dbiNames.names, key)
+		}
+	}
+}
+
+// dbiName returns the name dbi was opened with.
+func (txn *Txn) dbiName(dbi DBI) (string, bool) {
+	dbiNames.RLock()
+	defer dbiNames.RUnlock()
+	name, ok := dbiNames.names[dbiKey{C.mdb_txn_env(txn._txn), dbi}]
+	return name, ok
+}
+
+// The databases used by the package, such as the metadata database, are
+// opened once per environment and their handles are kept by the Env. LMDB
+// closes the handles opened by a transaction when it is aborted, which
+// includes every transaction run by View, and a write transaction that
+// opened a database in the same slot meanwhile then fails to commit with
+// BadDBI. The handles are therefore opened lazily by the first write
+// transaction using a database, and kept once it commits; DBIOpen records
+// the internal databases opened by write transactions. A read-only
+// transaction can only use handles that were open when it began, so the
+// internal databases that already exist are opened before the first one
+// begins.
+var internalDBNames = []string{metaDBName, changelogDBName}
+
+// openInternalDBIs opens the internal databases that exist and have no
+// handle yet, unless that was done before. A writer of this process may
+// be waiting for the reader that is about to begin, so if one holds the
+// write lock of the Env the databases are left to a later reader or to
+// the writers using them.
+func (env *Env) openInternalDBIs() {
+	env.internalMu.RLock()
+	done := env.internalOpen
+	env.internalMu.RUnlock()
+	if done {
+		return
+	}
+	select {
+	case env.writer <- struct{}{}:
+	default:
+		return
+	}
+	defer func() { <-env.writer }()
+	if env.openDBIsLocked(internalDBNames) == nil {
+		env.internalMu.Lock()
+		env.internalOpen = true
+		env.internalMu.Unlock()
+	}
+}
+
+// OpenNamedDBs opens the handles of all named databases in a transaction
+// that is committed, so that they stay open. LMDB closes the handles opened
+// by a read-only transaction when it ends, and a write transaction that
+// opened the same database meanwhile then fails to commit with BadDBI.
+// Programs whose read-only transactions open databases while other
+// goroutines write should call OpenNamedDBs after Open: DBIOpen then finds
+// the handles open, and databases created later are opened by the write
+// transactions that create them. SetMaxDBs must allow for all databases.
+// OpenNamedDBs waits for the write transactions of the process and must
+// not be called by one.
+func (env *Env) OpenNamedDBs() error {
+	env.writer <- struct{}{}
+	defer func() { <-env.writer }()
+	return env.openDBIsLocked(nil)
+}
+
+// openDBIsLocked opens the databases of names that exist, or all named
+// databases if names is nil. They are opened in a
+// read-only transaction, which keeps its handles when it is committed and
+// does not wait for the writers of other processes. The write lock of the
+// Env must be held, as LMDB must not open databases in two transactions at
+// once.
+func (env *Env) openDBIsLocked(names []string) error {
+	txn, err := env.BeginTxn(nil, RDONLY)
+	if err != nil {
+		return err
+	}
+	if names == nil {
+		main, err := txn.DBIOpen(nil, 0)
+		if err == nil {
+			names, _, err = txn.namedDBs(main)
+		}
+		if err != nil {
+			txn.Abort()
+			return err
+		}
+	}
+	dbis := map[string]DBI{}
+	for _, name := range names {
+		dbi, err := txn.DBIOpen(&name, 0)
+		if err == NotFound || err == DbsFull {
+			continue
+		}
+		if err != nil {
+			txn.Abort()
+			return err
+		}
+		dbis[name] = dbi
+	}
+	// Readers that begin after the commit must find the handles it keeps.
+	env.internalMu.Lock()
+	defer env.internalMu.Unlock()
+	err = txn.Commit()
+	if err != nil {
+		return err
+	}
+	for name, dbi := range dbis {
+		if isInternalDB(name) {
+			if env.internal == nil {
+				env.internal = map[string]DBI{}
+			}
+			env.internal[name] = dbi
+		}
+	}
+	return nil
+}
+
+// internalDBI returns the handle of the internal database called name. If
+// the database does not exist NotFound is returned, unless create is set and
+// txn is a write transaction, which then creates it.
+func (txn *Txn) internalDBI(name string, create bool) (DBI, error) {
+	env := txn.env
+	env.internalMu.RLock()
+	dbi, ok := env.internal[name]
+	env.internalMu.RUnlock()
+	if txn.rdonly {
+		if _, err := txn.Flags(dbi); ok && err == nil {
+			return dbi, nil
+		}
+		// Without a usable handle the database must not exist in the
+		// snapshot of txn, unless another process created it.
+		main, err := txn.DBIOpen(nil, 0)
+		if err != nil {
+			return 0, err
+		}
+		_, err = txn.GetVal(main, []byte(name))
+		if err == nil {
+			env.internalMu.Lock()
+			env.internalOpen = false
+			env.internalMu.Unlock()
+			err = fmt.Errorf("Database %s is not open; it is opened before the next read-only transaction begins", name)
+		}
+		return 0, err
+	}
+	if ok {
+		return dbi, nil
+	}
+	for t := txn; t != nil; t = t.parent {
+		if dbi, ok := t.dbis[name]; ok {
+			return dbi, nil
+		}
+	}
+	var flags uint
+	if create {
+		flags = CREATE
+	}
+	return txn.DBIOpen(&name, flags)
+}
+
+// isInternalDB reports whether name is the name of an internal database.
+func isInternalDB(name string) bool {
+	for _, n := range internalDBNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// keepDBIs hands the internal databases opened by a committed transaction
+// to its parent, or to the environment. env.internalMu must be held for a
+// top-level transaction.
+func (txn *Txn) keepDBIs() {
+	if len(txn.dbis) == 0 {
+		return
+	}
+	if txn.parent != nil {
+		if txn.parent.dbis == nil {
+			txn.parent.dbis = map[string]DBI{}
+		}
+		for name, dbi := range txn.dbis {
+			txn.parent.dbis[name] = dbi
+		}
+		return
+	}
+	if txn.env.internal == nil {
+		txn.env.internal = map[string]DBI{}
+	}
+	for name, dbi := range txn.dbis {
+		txn.env.internal[name] = dbi
+	}
+}
+
+// forgetInternalDBI drops dbi from the internal handles after it was closed.
+func (env *Env) forgetInternalDBI(dbi DBI) {
+	env.internalMu.Lock()
+	defer env.internalMu.Unlock()
+	for name, h := range env.internal {
+		if h == dbi {
+			delete(env.internal, name)
+		}
+	}
+}
+
 func (txn *Txn) Stat(dbi DBI) (*Stat, error) {
 	var _stat C.MDB_stat
 	ret := C.mdb_stat(txn._txn, C.MDB_dbi(dbi), &_stat)
@@ -123,9 +577,136 @@ func (txn *Txn) Stat(dbi DBI) (*Stat, error) {
 	return &stat, nil
 }
 
+// FREE_DBI is the handle of the database in which LMDB records the free
+// pages of the environment. It can be read with Stat and cursors.
+const FREE_DBI DBI = 0
+
+// FreePages returns the number of pages on the free lists of the
+// environment as seen by txn. Free pages of transactions that are still
+// visible to some reader are included.
+func (txn *Txn) FreePages() (uint64, error) {
+	cursor, err := txn.CursorOpen(FREE_DBI)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+	var pages uint64
+	_, val, err := cursor.GetVal(nil, nil, NEXT)
+	for err == nil {
+		// Each entry is a page list starting with its length.
+		if val.mv_size >= C.size_t(unsafe.Sizeof(C.size_t(0))) {
+			pages += uint64(*(*C.size_t)(val.mv_data))
+		}
+		_, val, err = cursor.GetVal(nil, nil, NEXT)
+	}
+	if err != NotFound {
+		return 0, err
+	}
+	return pages, nil
+}
+
+// Flags returns the flags dbi was created with.
+func (txn *Txn) Flags(dbi DBI) (uint, error) {
+	var flags C.uint
+	ret := C.mdb_dbi_flags(txn._txn, C.MDB_dbi(dbi), &flags)
+	if ret != SUCCESS {
+		return 0, errno(ret)
+	}
+	return uint(flags), nil
+}
+
+// namedDBs returns the names of the named databases stored in main and
+// whether main also holds other entries. Keys containing NUL bytes are not
+// valid names and are treated as other entries.
+func (txn *Txn) namedDBs(main DBI) (names []string, plain bool, err error) {
+	cursor, err := txn.CursorOpen(main)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cursor.Close()
+	key, _, err := cursor.Get(nil, nil, NEXT_NODUP)
+	for err == nil {
+		name := string(key)
+		if bytes.IndexByte(key, 0) < 0 {
+			_, err = txn.DBIOpen(&name, 0)
+		} else {
+			err = Incompatibile
+		}
+		switch err {
+		case nil:
+			names = append(names, name)
+		case Incompatibile:
+			plain = true
+		default:
+			return nil, false, err
+		}
+		key, _, err = cursor.Get(nil, nil, NEXT_NODUP)
+	}
+	if err != NotFound {
+		return nil, false, err
+	}
+	return names, plain, nil
+}
+
+// DBInfo describes a named database.
+type DBInfo struct {
+	Name  string
+	Flags uint // flags the database was created with
+	Stat  Stat
+}
+
+// ListDBs returns the named databases of the environment in name order. The
+// databases are opened to read their flags and statistics, so SetMaxDBs must
+// allow for all of them.
+func (txn *Txn) ListDBs() ([]DBInfo, error) {
+	main, err := txn.DBIOpen(nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	names, _, err := txn.namedDBs(main)
+	if err != nil {
+		return nil, err
+	}
+	dbs := make([]DBInfo, len(names))
+	for i, name := range names {
+		dbi, err := txn.DBIOpen(&name, 0)
+		if err != nil {
+			return nil, err
+		}
+		flags, err := txn.Flags(dbi)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := txn.Stat(dbi)
+		if err != nil {
+			return nil, err
+		}
+		dbs[i] = DBInfo{Name: name, Flags: flags, Stat: *stat}
+	}
+	return dbs, nil
+}
+
 func (txn *Txn) Drop(dbi DBI, del int) error {
+	if txn.changes != nil {
+		// Look up the database before LMDB closes the handle.
+		txn.changes.db(txn, dbi)
+	}
 	ret := C.mdb_drop(txn._txn, C.MDB_dbi(dbi), C.int(del))
-	return errno(ret)
+	if ret != SUCCESS {
+		return errno(ret)
+	}
+	if txn.changes != nil {
+		op := ChangeEmpty
+		if del != 0 {
+			op = ChangeDrop
+		}
+		txn.changes.add(txn, dbi, op, nil, nil)
+	}
+	if del != 0 && txn.env != nil {
+		// LMDB closes the handle of a deleted database.
+		txn.env.forgetInternalDBI(dbi)
+	}
+	return nil
 }
 
 func (txn *Txn) Get(dbi DBI, key []byte) ([]byte, error) {
@@ -147,22 +728,134 @@ func (txn *Txn) Put(dbi DBI, key []byte, val []byte, flags uint) error {
 	ckey := Wrap(key)
 	cval := Wrap(val)
 	ret := C.mdb_put(txn._txn, C.MDB_dbi(dbi), (*C.MDB_val)(&ckey), (*C.MDB_val)(&cval), C.uint(flags))
+	if ret == SUCCESS && txn.changes != nil {
+		txn.changes.add(txn, dbi, ChangePut, key, val)
+	}
 	return errno(ret)
 }
 
 func (txn *Txn) Del(dbi DBI, key, val []byte) error {
 	ckey := Wrap(key)
+	var ret C.int
 	if val == nil {
-		ret := C.mdb_del(txn._txn, C.MDB_dbi(dbi), (*C.MDB_val)(&ckey), nil)
-		return errno(ret)
+		ret = C.mdb_del(txn._txn, C.MDB_dbi(dbi), (*C.MDB_val)(&ckey), nil)
+	} else {
+		cval := Wrap(val)
+		ret = C.mdb_del(txn._txn, C.MDB_dbi(dbi), (*C.MDB_val)(&ckey), (*C.MDB_val)(&cval))
+	}
+	if ret == SUCCESS && txn.changes != nil {
+		txn.changes.add(txn, dbi, ChangeDel, key, val)
 	}
-	cval := Wrap(val)
-	ret := C.mdb_del(txn._txn, C.MDB_dbi(dbi), (*C.MDB_val)(&ckey), (*C.MDB_val)(&cval))
 	return errno(ret)
 }
 
+// KV is a key/value pair written by Txn.PutMany.
+type KV struct {
+	Key []byte
+	Val []byte
+}
+
+// BatchError is returned by GetMany and PutMany for the first item that
+// failed.
+type BatchError struct {
+	Index int   // index of the failed item
+	Err   error // error of the failed item
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("item %d: %s", e.Index, e.Err)
+}
+
+// copyVals copies ps to the C memory at data and points vals to the copies.
+// It returns the address following the copies.
+func copyVals(vals []C.MDB_val, ps [][]byte, data unsafe.Pointer) unsafe.Pointer {
+	for i, p := range ps {
+		vals[i].mv_size = C.size_t(len(p))
+		vals[i].mv_data = data
+		if len(p) > 0 {
+			copy(unsafe.Slice((*byte)(data), len(p)), p)
+			data = unsafe.Add(data, len(p))
+		}
+	}
+	return data
+}
+
+// GetMany looks up multiple keys with a single call into C. The returned
+// slice holds a copy of the value of each key, or nil if the key was not
+// found. If a lookup fails for another reason a *BatchError for the first
+// failure is returned along with the values.
+func (txn *Txn) GetMany(dbi DBI, keys [][]byte) ([][]byte, error) {
+	n := uintptr(len(keys))
+	if n == 0 {
+		return nil, nil
+	}
+	// One allocation holds the keys, the values, the results and the key data.
+	valSize := unsafe.Sizeof(C.MDB_val{})
+	size := n * (2*valSize + unsafe.Sizeof(C.int(0)))
+	for _, key := range keys {
+		size += uintptr(len(key))
+	}
+	buf := C.malloc(C.size_t(size))
+	defer C.free(buf)
+	ckeys := unsafe.Slice((*C.MDB_val)(buf), n)
+	cvals := unsafe.Slice((*C.MDB_val)(unsafe.Add(buf, n*valSize)), n)
+	rcs := unsafe.Slice((*C.int)(unsafe.Add(buf, 2*n*valSize)), n)
+	copyVals(ckeys, keys, unsafe.Add(buf, n*(2*valSize+unsafe.Sizeof(C.int(0)))))
+
+	C.gomdb_get_many(txn._txn, C.MDB_dbi(dbi), &ckeys[0], &cvals[0], &rcs[0], C.size_t(n))
+	vals := make([][]byte, n)
+	var err error
+	for i, rc := range rcs {
+		switch {
+		case rc == SUCCESS:
+			vals[i] = Val(cvals[i]).Bytes()
+		case rc != C.MDB_NOTFOUND && err == nil:
+			err = &BatchError{i, errno(rc)}
+		}
+	}
+	return vals, err
+}
+
+// PutMany stores multiple items with a single call into C. It stops at the
+// first item that cannot be stored and returns a *BatchError for it; the
+// items before it remain stored.
+func (txn *Txn) PutMany(dbi DBI, items []KV, flags uint) error {
+	n := uintptr(len(items))
+	if n == 0 {
+		return nil
+	}
+	keys := make([][]byte, n)
+	vals := make([][]byte, n)
+	valSize := unsafe.Sizeof(C.MDB_val{})
+	size := 2 * n * valSize
+	for i, item := range items {
+		keys[i], vals[i] = item.Key, item.Val
+		size += uintptr(len(item.Key) + len(item.Val))
+	}
+	// One allocation holds the keys, the values and their data.
+	buf := C.malloc(C.size_t(size))
+	defer C.free(buf)
+	ckeys := unsafe.Slice((*C.MDB_val)(buf), n)
+	cvals := unsafe.Slice((*C.MDB_val)(unsafe.Add(buf, n*valSize)), n)
+	data := copyVals(ckeys, keys, unsafe.Add(buf, 2*n*valSize))
+	copyVals(cvals, vals, data)
+
+	var ret C.int
+	i := C.gomdb_put_many(txn._txn, C.MDB_dbi(dbi), &ckeys[0], &cvals[0], C.uint(flags), C.size_t(n), &ret)
+	if txn.changes != nil {
+		for _, item := range items[:i] {
+			txn.changes.add(txn, dbi, ChangePut, item.Key, item.Val)
+		}
+	}
+	if ret != SUCCESS {
+		return &BatchError{int(i), errno(ret)}
+	}
+	return nil
+}
+
 type Cursor struct {
 	_cursor *C.MDB_cursor
+	txn     *Txn // transaction the cursor was opened or renewed in
 }
 
 func (txn *Txn) CursorOpen(dbi DBI) (*Cursor, error) {
@@ -171,27 +864,16 @@ func (txn *Txn) CursorOpen(dbi DBI) (*Cursor, error) {
 	if ret != SUCCESS {
 		return nil, errno(ret)
 	}
-	return &Cursor{_cursor}, nil
+	return &Cursor{_cursor: _cursor, txn: txn}, nil
 }
 
 func (txn *Txn) CursorRenew(cursor *Cursor) error {
 	ret := C.mdb_cursor_renew(txn._txn, cursor._cursor)
+	if ret == SUCCESS {
+		cursor.txn = txn
+	}
 	return errno(ret)
 }
 
-/*
-type CmpFunc func(a, b []byte) int
-
-func (txn *Txn) SetCompare(dbi DBI, cmp CmpFunc) error {
-    f := func(a, b *C.MDB_val) C.int {
-        ga := C.GoBytes(a.mv_data, C.int(a.mv_size))
-        gb := C.GoBytes(a.mv_data, C.int(a.mv_size))
-        return C.int(cmp(ga, gb))
-    }
-    ret := C.mdb_set_compare(txn._txn, C.MDB_dbi(dbi), *unsafe.Pointer(&f))
-    return errno(ret)
-}
-*/
-// func (txn *Txn) SetDupSort(dbi DBI, comp *C.MDB_comp_func) error
 // func (txn *Txn) SetRelFunc(dbi DBI, rel *C.MDB_rel_func) error
 // func (txn *Txn) SetRelCtx(dbi DBI, void *) error
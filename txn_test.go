@@ -0,0 +1,355 @@
+package mdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestUpdateView(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("key"), []byte("val"), 0)
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+
+	errFail := errors.New("fail")
+	err = env.Update(func(txn *Txn) error {
+		err := txn.Put(dbi, []byte("key"), []byte("other"), 0)
+		if err != nil {
+			return err
+		}
+		return errFail
+	})
+	if err != errFail {
+		t.Fatalf("Update returned %v, expected %v", err, errFail)
+	}
+
+	func() {
+		defer func() {
+			if e := recover(); e != "boom" {
+				t.Errorf("unexpected panic value: %v", e)
+			}
+		}()
+		env.Update(func(txn *Txn) error {
+			txn.Put(dbi, []byte("key"), []byte("panic"), 0)
+			panic("boom")
+		})
+	}()
+
+	err = env.View(func(txn *Txn) error {
+		val, err := txn.Get(dbi, []byte("key"))
+		if err != nil {
+			return err
+		}
+		if string(val) != "val" {
+			t.Errorf("unexpected value: %q", val)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestUpdateGrowth(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	err := env.SetMapSize(1 << 16)
+	if err != nil {
+		t.Fatalf("Cannot set mapsize: %s", err)
+	}
+	var dbi DBI
+	err = env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.DBIOpen(nil, 0)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Cannot open DBI: %s", err)
+	}
+	fill := func(txn *Txn) error {
+		val := make([]byte, 1024)
+		for i := 0; i < 1024; i++ {
+			key := []byte(fmt.Sprintf("Key-%04d", i))
+			err := txn.Put(dbi, key, val, 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = env.Update(fill)
+	if err != MapFull {
+		t.Fatalf("Expected MapFull without growth policy: %v", err)
+	}
+
+	env.SetGrowthPolicy(&GrowthPolicy{MaxSize: 1 << 18})
+	err = env.Update(fill)
+	if err != MapFull {
+		t.Fatalf("Expected MapFull when growth is capped: %v", err)
+	}
+	info, err := env.Info()
+	if err != nil {
+		t.Fatalf("Cannot get info: %s", err)
+	}
+	if info.MapSize != 1<<18 {
+		t.Errorf("Map size not grown to the cap: %d", info.MapSize)
+	}
+
+	// The map is not resized under an active reader.
+	env.SetGrowthPolicy(&GrowthPolicy{Step: 1 << 20})
+	rtxn, err := env.BeginTxn(nil, RDONLY)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction: %s", err)
+	}
+	err = env.Update(fill)
+	if err != MapFull {
+		t.Fatalf("Expected MapFull with an active reader: %v", err)
+	}
+	info, err = env.Info()
+	if err != nil {
+		t.Fatalf("Cannot get info: %s", err)
+	}
+	if info.MapSize != 1<<18 {
+		t.Errorf("Map size changed under a reader: %d", info.MapSize)
+	}
+	rtxn.Reset()
+	err = env.Update(fill)
+	if err != nil {
+		t.Fatalf("Update with growth policy: %s", err)
+	}
+	err = rtxn.Renew()
+	if err != nil {
+		t.Fatalf("Cannot renew transaction: %s", err)
+	}
+	rtxn.Abort()
+	if env.readers != 0 {
+		t.Errorf("%d readers after abort", env.readers)
+	}
+	stat, err := env.Stat()
+	if err != nil {
+		t.Fatalf("Cannot get stat: %s", err)
+	}
+	if stat.Entries != 1024 {
+		t.Errorf("Unexpected number of entries: %d", stat.Entries)
+	}
+}
+
+func TestGetPutMany(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+
+	var dbi DBI
+	err := env.Update(func(txn *Txn) (err error) {
+		dbi, err = txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		err = txn.PutMany(dbi, []KV{
+			{[]byte("a"), []byte("1")},
+			{[]byte("b"), nil},
+			{[]byte("c"), []byte("3")},
+		}, 0)
+		if err != nil {
+			return err
+		}
+		err = txn.PutMany(dbi, []KV{
+			{[]byte("d"), []byte("4")},
+			{[]byte("a"), []byte("x")},
+			{[]byte("e"), []byte("5")},
+		}, NOOVERWRITE)
+		berr, ok := err.(*BatchError)
+		if !ok || berr.Index != 1 || berr.Err != KeyExist {
+			t.Errorf("Unexpected PutMany error: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+
+	err = env.View(func(txn *Txn) error {
+		vals, err := txn.GetMany(dbi, [][]byte{[]byte("c"), []byte("x"), []byte("a"), []byte("b"), []byte("d"), []byte("e")})
+		if err != nil {
+			return err
+		}
+		expected := []string{"3", "", "1", "", "4", ""}
+		for i, val := range vals {
+			if string(val) != expected[i] {
+				t.Errorf("Value %d is %q, expected %q", i, val, expected[i])
+			}
+		}
+		if vals[1] != nil || vals[3] == nil || vals[5] != nil {
+			t.Errorf("Missing keys not distinguished from empty values: %q", vals)
+		}
+
+		_, err = txn.GetMany(dbi, [][]byte{[]byte("a"), nil})
+		berr, ok := err.(*BatchError)
+		if !ok || berr.Index != 1 {
+			t.Errorf("Unexpected GetMany error for an empty key: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestListDBs(t *testing.T) {
+	env := setupMaxDBs(t, 3)
+	defer clean(env, t)
+
+	err := env.Update(func(txn *Txn) error {
+		main, err := txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		err = txn.Put(main, []byte("plain"), []byte("value"), 0)
+		if err != nil {
+			return err
+		}
+		for _, db := range []struct {
+			name  string
+			flags uint
+		}{{"b", DUPSORT | DUPFIXED}, {"a", 0}} {
+			dbi, err := txn.DBIOpen(&db.name, CREATE|db.flags)
+			if err != nil {
+				return err
+			}
+			err = txn.Put(dbi, []byte("key"), []byte("val"), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+	err = env.View(func(txn *Txn) error {
+		dbs, err := txn.ListDBs()
+		if err != nil {
+			return err
+		}
+		if len(dbs) != 2 {
+			t.Fatalf("Unexpected databases: %+v", dbs)
+		}
+		if dbs[0].Name != "a" || dbs[0].Flags != 0 || dbs[0].Stat.Entries != 1 {
+			t.Errorf("Unexpected database a: %+v", dbs[0])
+		}
+		if dbs[1].Name != "b" || dbs[1].Flags != DUPSORT|DUPFIXED || dbs[1].Stat.Entries != 1 {
+			t.Errorf("Unexpected database b: %+v", dbs[1])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
+
+func TestSetGrowthPolicyConcurrent(t *testing.T) {
+	env := setup(t)
+	defer clean(env, t)
+	err := env.SetMapSize(1 << 16)
+	if err != nil {
+		t.Fatalf("Cannot set map size: %s", err)
+	}
+	env.SetGrowthPolicy(&GrowthPolicy{Step: 1 << 16})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			env.SetGrowthPolicy(&GrowthPolicy{Step: uint64(i+1) << 20})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		err := env.Batch(func(txn *Txn) error {
+			dbi, err := txn.DBIOpen(nil, 0)
+			if err != nil {
+				return err
+			}
+			return txn.Put(dbi, []byte(fmt.Sprintf("key%d", i)), make([]byte, 2048), 0)
+		})
+		if err != nil {
+			t.Fatalf("Cannot batch: %s", err)
+		}
+	}
+	<-done
+}
+
+func TestOpenNamedDBs(t *testing.T) {
+	env := setupMaxDBs(t, 4)
+	path, err := env.Path()
+	if err != nil {
+		t.Fatalf("Cannot get path: %s", err)
+	}
+	defer os.RemoveAll(path)
+	name := "items"
+	err = env.Update(func(txn *Txn) error {
+		dbi, err := txn.DBIOpen(&name, CREATE)
+		if err != nil {
+			return err
+		}
+		return txn.Put(dbi, []byte("a"), []byte("1"), 0)
+	})
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+	env.Close()
+
+	env, err = NewEnv()
+	if err != nil {
+		t.Fatalf("Cannot create environment: %s", err)
+	}
+	defer env.Close()
+	err = env.SetMaxDBs(4)
+	if err != nil {
+		t.Fatalf("Cannot set maxdbs: %s", err)
+	}
+	err = env.Open(path, 0, 0664)
+	if err != nil {
+		t.Fatalf("Cannot open environment: %s", err)
+	}
+	err = env.OpenNamedDBs()
+	if err != nil {
+		t.Fatalf("Cannot open databases: %s", err)
+	}
+	// A read transaction opening the database while a writer does must
+	// not close the handle of the writer.
+	rtxn, err := env.BeginTxn(nil, RDONLY)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction: %s", err)
+	}
+	_, err = rtxn.DBIOpen(&name, 0)
+	if err != nil {
+		t.Fatalf("Cannot open database: %s", err)
+	}
+	wtxn, err := env.BeginTxn(nil, 0)
+	if err != nil {
+		t.Fatalf("Cannot begin transaction: %s", err)
+	}
+	dbi, err := wtxn.DBIOpen(&name, 0)
+	if err == nil {
+		err = wtxn.Put(dbi, []byte("b"), []byte("2"), 0)
+	}
+	if err != nil {
+		t.Fatalf("Cannot put: %s", err)
+	}
+	rtxn.Abort()
+	err = wtxn.Commit()
+	if err != nil {
+		t.Fatalf("Cannot commit: %s", err)
+	}
+}
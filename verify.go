@@ -0,0 +1,704 @@
+package mdb
+
+/*
+#cgo CFLAGS: -pthread -W -Wall -Wno-unused-parameter -Wbad-function-cast -O2 -g
+#include "lmdb.h"
+
+// gomdb_verify_cmp compares a and b with the key comparator of dbi, or with
+// its duplicate comparator if dup is set.
+static int gomdb_verify_cmp(MDB_txn *txn, MDB_dbi dbi, int dup, void *a, size_t asize, void *b, size_t bsize) {
+	MDB_val va, vb;
+	va.mv_size = asize;
+	va.mv_data = a;
+	vb.mv_size = bsize;
+	vb.mv_data = b;
+	if (dup)
+		return mdb_dcmp(txn, dbi, &va, &vb);
+	return mdb_cmp(txn, dbi, &va, &vb);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"unsafe"
+)
+
+// VerifyOptions controls Env.Verify.
+type VerifyOptions struct {
+	SkipOrder   bool // Do not check the order of keys and duplicates.
+	MaxProblems int  // Stop after this many problems. Zero means no limit.
+}
+
+// VerifyProblem is an inconsistency found by Env.Verify.
+type VerifyProblem struct {
+	DB       string // Name of the database, empty for the main database.
+	FreeList bool   // The problem concerns the free list or the page accounting.
+	Key      []byte // Key of the entry, if the problem concerns one.
+	Message  string
+}
+
+func (p VerifyProblem) String() string {
+	where := "main database"
+	switch {
+	case p.FreeList:
+		where = "free list"
+	case p.DB != "":
+		where = "database " + p.DB
+	}
+	if p.Key != nil {
+		return fmt.Sprintf("%s: key %x: %s", where, p.Key, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", where, p.Message)
+}
+
+// VerifyReport is the result of Env.Verify.
+type VerifyReport struct {
+	DBs       int    // Number of databases checked, including the main database.
+	Entries   uint64 // Number of entries of all databases.
+	Pages     uint64 // Number of pages of the environment, LastPNO+1.
+	UsedPages uint64 // Pages of the meta pages and of the B-trees of all databases and the free list.
+	FreePages uint64 // Pages on the free list.
+	Problems  []VerifyProblem
+	Truncated bool // The check stopped after MaxProblems problems.
+}
+
+// OK reports whether no problems were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+var errTooManyProblems = errors.New("too many problems")
+
+// The state of a page during the check.
+const (
+	pageUnseen = iota
+	pageUsed
+	pageFree
+)
+
+// verifier holds the state of a consistency check.
+type verifier struct {
+	txn    *Txn
+	file   *os.File
+	opts   VerifyOptions
+	psize  uint64
+	pages  []byte // state of each page
+	named  []*tree
+	report *VerifyReport
+}
+
+// tree is a B-tree walked by the check: the free list, a database or the
+// duplicates of a key.
+type tree struct {
+	db       string // name of the database
+	freeList bool
+	main     bool
+	dupKey   []byte // key of the duplicates
+	rec      dbRecord
+	cmp      func(a, b []byte) int // order of the keys, nil if not checked
+	dcmp     func(a, b []byte) int // order of the duplicates, nil if not checked
+	prev     []byte
+	started  bool // prev is set
+	badDepth bool // a leaf at the wrong depth was reported
+
+	branch, leaf, overflow, entries uint64
+}
+
+// dbRecord is an MDB_db record.
+type dbRecord struct {
+	flags, depth                          uint16
+	branch, leaf, overflow, entries, root uint64
+}
+
+func parseDBRecord(p []byte) dbRecord {
+	return dbRecord{
+		flags:    nativeEndian.Uint16(p[dbFlagsOff:]),
+		depth:    nativeEndian.Uint16(p[dbDepthOff:]),
+		branch:   nativeEndian.Uint64(p[dbBranchOff:]),
+		leaf:     nativeEndian.Uint64(p[dbLeafOff:]),
+		overflow: nativeEndian.Uint64(p[dbOverflowOff:]),
+		entries:  nativeEndian.Uint64(p[dbEntriesOff:]),
+		root:     nativeEndian.Uint64(p[dbRootOff:]),
+	}
+}
+
+func (v *verifier) problem(p VerifyProblem) error {
+	v.report.Problems = append(v.report.Problems, p)
+	if v.opts.MaxProblems > 0 && len(v.report.Problems) >= v.opts.MaxProblems {
+		v.report.Truncated = true
+		return errTooManyProblems
+	}
+	return nil
+}
+
+// treeProblem reports a problem of t. The key defaults to the key of the
+// duplicates walked by t.
+func (v *verifier) treeProblem(t *tree, key []byte, format string, args ...interface{}) error {
+	if key == nil {
+		key = t.dupKey
+	}
+	if key != nil {
+		key = append([]byte{}, key...)
+	}
+	return v.problem(VerifyProblem{DB: t.db, FreeList: t.freeList, Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// Verify checks the consistency of the environment in a read-only
+// transaction, so it can run while the environment is in use. The pages of
+// the snapshot are read from the data file, and the B-trees of the free
+// list, of the main database and of every named database, including the
+// sub-databases holding duplicates, are walked page by page. Each page
+// must be in range, carry its own number and the expected type, and belong
+// to exactly one tree or to the free list, and every page of the
+// environment must be either used or free. The page, entry and depth
+// counts of each tree are checked against its statistics.
+//
+// Unless SkipOrder is set the order of keys and duplicates is checked too.
+// Databases are checked with the default order given by their flags, or
+// with the comparators set on their handle if it is open; a database with
+// a custom comparator must therefore be open, or the order check skipped.
+// Verify opens no handles, so it works on environments opened with RDONLY
+// and does not count against SetMaxDBs. The returned error is only set if
+// the check could not be run; the problems found are listed in the report.
+func (env *Env) Verify(opts VerifyOptions) (*VerifyReport, error) {
+	err := checkLayout()
+	if err != nil {
+		return nil, err
+	}
+	path, err := env.Path()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := env.Flags()
+	if err != nil {
+		return nil, err
+	}
+	if flags&NOSUBDIR == 0 {
+		path = filepath.Join(path, "data.mdb")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	txn, meta, err := env.beginVerify(f)
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Abort()
+	v := &verifier{txn: txn, file: f, opts: opts}
+	v.psize = uint64(nativeEndian.Uint32(meta[metaPSizeOff:]))
+	npages := nativeEndian.Uint64(meta[metaLastPgOff:]) + 1
+	if v.psize < pageHeaderSize+nodeSize || npages < 2 || npages > uint64(1)<<40 {
+		return nil, errDataFormat
+	}
+	v.pages = make([]byte, npages)
+	v.report = &VerifyReport{Pages: npages}
+	err = v.run(meta)
+	if err == errTooManyProblems {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range v.pages {
+		switch state {
+		case pageUsed:
+			v.report.UsedPages++
+		case pageFree:
+			v.report.FreePages++
+		}
+	}
+	return v.report, nil
+}
+
+// beginVerify begins a read-only transaction and returns it with the meta
+// page of its snapshot read from the data file f, retrying if a write
+// commits in between.
+func (env *Env) beginVerify(f *os.File) (*Txn, []byte, error) {
+	for i := 0; i < 100; i++ {
+		before, err := env.Info()
+		if err != nil {
+			return nil, nil, err
+		}
+		txn, err := env.BeginTxn(nil, RDONLY)
+		if err != nil {
+			return nil, nil, err
+		}
+		after, err := env.Info()
+		if err != nil {
+			txn.Abort()
+			return nil, nil, err
+		}
+		if before.LastTxnID == after.LastTxnID {
+			meta, err := readMeta(f, after.LastTxnID)
+			if err != nil {
+				txn.Abort()
+				return nil, nil, err
+			}
+			if meta != nil {
+				return txn, meta, nil
+			}
+		}
+		txn.Abort()
+	}
+	return nil, nil, errors.New("Environment changed too often to begin the check")
+}
+
+// readMeta returns the meta page of the data file f written by the
+// transaction txnid, or nil if it has been overwritten since.
+func readMeta(f *os.File, txnid uint64) ([]byte, error) {
+	var psize int64
+	for i := int64(0); i < 2; i++ {
+		meta := make([]byte, metaSize)
+		_, err := f.ReadAt(meta, i*psize)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		err = checkMeta(meta)
+		if err != nil {
+			return nil, err
+		}
+		psize = int64(nativeEndian.Uint32(meta[metaPSizeOff:]))
+		if nativeEndian.Uint64(meta[metaTxnIDOff:]) != txnid {
+			continue
+		}
+		// The page is rewritten two commits later, possibly while it is
+		// read; it is intact if it did not change.
+		again := make([]byte, metaSize)
+		_, err = f.ReadAt(again, i*psize)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if bytes.Equal(meta, again) {
+			return meta, nil
+		}
+	}
+	return nil, nil
+}
+
+func (v *verifier) run(meta []byte) error {
+	v.pages[0], v.pages[1] = pageUsed, pageUsed
+	free := &tree{freeList: true, rec: parseDBRecord(meta[metaDBOff(FREE_DBI):])}
+	v.setOrder(free, FREE_DBI, false)
+	err := v.walk(free)
+	if err != nil {
+		return err
+	}
+	main := &tree{main: true, rec: parseDBRecord(meta[metaDBOff(mainDBI):])}
+	v.setOrder(main, mainDBI, true)
+	err = v.walk(main)
+	if err != nil {
+		return err
+	}
+	v.report.DBs++
+	v.report.Entries += main.entries
+	for _, t := range v.named {
+		dbi, open := v.handle(t.db)
+		v.setOrder(t, dbi, open)
+		err = v.walk(t)
+		if err != nil {
+			return err
+		}
+		v.report.DBs++
+		v.report.Entries += t.entries
+	}
+	for pgno, state := range v.pages {
+		if state == pageUnseen {
+			return v.problem(VerifyProblem{FreeList: true, Message: fmt.Sprintf(
+				"page %d is neither used nor free", pgno)})
+		}
+	}
+	return nil
+}
+
+// handle returns a handle of the named database that is valid in the
+// transaction of the check, if one is open.
+func (v *verifier) handle(name string) (DBI, bool) {
+	env := C.mdb_txn_env(v.txn._txn)
+	var dbis []DBI
+	dbiNames.RLock()
+	for key, n := range dbiNames.names {
+		if key.env == env && n == name {
+			dbis = append(dbis, key.dbi)
+		}
+	}
+	dbiNames.RUnlock()
+	for _, dbi := range dbis {
+		if _, err := v.txn.Flags(dbi); err == nil {
+			return dbi, true
+		}
+	}
+	return 0, false
+}
+
+// setOrder sets the orders of the keys and duplicates of t to the default
+// order of its flags, or to the comparators set on dbi if it is open.
+func (v *verifier) setOrder(t *tree, dbi DBI, open bool) {
+	if v.opts.SkipOrder {
+		return
+	}
+	flags := uint(t.rec.flags)
+	t.cmp = defaultOrder(flags&INTEGERKEY != 0, flags&REVERSEKEY != 0)
+	t.dcmp = defaultOrder(flags&INTEGERDUP != 0, flags&REVERSEDUP != 0)
+	if !open {
+		return
+	}
+	env := C.mdb_txn_env(v.txn._txn)
+	if hasCmp(env, dbi, false) {
+		t.cmp = v.compare(dbi, false)
+	}
+	if hasCmp(env, dbi, true) {
+		t.dcmp = v.compare(dbi, true)
+	}
+}
+
+// compare returns a function comparing keys or duplicates with the
+// comparator of dbi.
+func (v *verifier) compare(dbi DBI, dup bool) func(a, b []byte) int {
+	var cdup C.int
+	if dup {
+		cdup = 1
+	}
+	ptr := func(p []byte) unsafe.Pointer {
+		if len(p) == 0 {
+			return nil
+		}
+		return unsafe.Pointer(&p[0])
+	}
+	return func(a, b []byte) int {
+		return int(C.gomdb_verify_cmp(v.txn._txn, C.MDB_dbi(dbi), cdup,
+			ptr(a), C.size_t(len(a)), ptr(b), C.size_t(len(b))))
+	}
+}
+
+// defaultOrder returns the order LMDB uses for keys or duplicates without
+// a custom comparator.
+func defaultOrder(integer, reverse bool) func(a, b []byte) int {
+	// Integers are compared as unsigned numbers in the byte order of the
+	// host.
+	if integer && nativeEndian == binary.BigEndian {
+		return bytes.Compare
+	}
+	if integer || reverse {
+		return compareReverse
+	}
+	return bytes.Compare
+}
+
+// compareReverse compares a and b starting from their last bytes.
+func compareReverse(a, b []byte) int {
+	for i := 1; i <= len(a) && i <= len(b); i++ {
+		if c := int(a[len(a)-i]) - int(b[len(b)-i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// walk walks the pages of t and checks its statistics.
+func (v *verifier) walk(t *tree) error {
+	r := t.rec
+	if r.root == invalidPgno {
+		if r.entries+r.branch+r.leaf+r.overflow+uint64(r.depth) != 0 {
+			return v.treeProblem(t, nil, "pages or depth without entries")
+		}
+		return nil
+	}
+	err := v.page(t, r.root, 1)
+	if err != nil {
+		return err
+	}
+	for _, c := range []struct {
+		what        string
+		found, stat uint64
+	}{
+		{"entries", t.entries, r.entries},
+		{"branch pages", t.branch, r.branch},
+		{"leaf pages", t.leaf, r.leaf},
+		{"overflow pages", t.overflow, r.overflow},
+	} {
+		if c.found != c.stat {
+			err = v.treeProblem(t, nil, "%d %s found, statistics report %d", c.found, c.what, c.stat)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// read reads n pages starting at pgno from the data file.
+func (v *verifier) read(pgno, n uint64) ([]byte, error) {
+	p := make([]byte, n*v.psize)
+	_, err := v.file.ReadAt(p, int64(pgno*v.psize))
+	return p, err
+}
+
+// claim marks n pages starting at pgno as used by t and returns them, or
+// nil if a problem was found.
+func (v *verifier) claim(t *tree, pgno, n uint64) ([]byte, error) {
+	npages := uint64(len(v.pages))
+	if pgno < 2 || pgno >= npages || n > npages-pgno {
+		return nil, v.treeProblem(t, nil, "page %d out of range", pgno)
+	}
+	for i := pgno; i < pgno+n; i++ {
+		switch v.pages[i] {
+		case pageUsed:
+			return nil, v.treeProblem(t, nil, "page %d is used twice", i)
+		case pageFree:
+			return nil, v.treeProblem(t, nil, "page %d is used and free", i)
+		}
+	}
+	for i := pgno; i < pgno+n; i++ {
+		v.pages[i] = pageUsed
+	}
+	p, err := v.read(pgno, n)
+	if err == io.EOF {
+		return nil, v.treeProblem(t, nil, "page %d is beyond the end of the data file", pgno)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if number := nativeEndian.Uint64(p[pageNumberOff:]); number != pgno {
+		return nil, v.treeProblem(t, nil, "page %d has the number %d", pgno, number)
+	}
+	return p, nil
+}
+
+// numKeys returns the number of keys of the page p, or false if its header
+// is invalid.
+func numKeys(p []byte) (int, bool) {
+	lower := int(nativeEndian.Uint16(p[pageLowerOff:]))
+	upper := int(nativeEndian.Uint16(p[pageUpperOff:]))
+	if lower < pageHeaderSize || lower > upper || upper > len(p) {
+		return 0, false
+	}
+	return (lower - pageHeaderSize) / 2, true
+}
+
+// node returns node i of the page p with n nodes, or nil if the node or
+// its key do not fit into the page.
+func node(p []byte, n, i int) []byte {
+	off := int(nativeEndian.Uint16(p[pageHeaderSize+2*i:]))
+	if off < pageHeaderSize+2*n || off+nodeSize > len(p) {
+		return nil
+	}
+	nd := p[off:]
+	if nodeSize+int(nativeEndian.Uint16(nd[nodeKeySizeOff:])) > len(nd) {
+		return nil
+	}
+	return nd
+}
+
+// page walks the page pgno of t at the given depth.
+func (v *verifier) page(t *tree, pgno uint64, depth int) error {
+	p, err := v.claim(t, pgno, 1)
+	if p == nil {
+		return err
+	}
+	n, ok := numKeys(p)
+	if !ok {
+		return v.treeProblem(t, nil, "page %d has an invalid header", pgno)
+	}
+	flags := nativeEndian.Uint16(p[pageFlagsOff:])
+	switch {
+	case flags&(pageBranch|pageLeaf|pageOverflow) == pageBranch:
+		t.branch++
+		for i := 0; i < n; i++ {
+			nd := node(p, n, i)
+			if nd == nil {
+				return v.treeProblem(t, nil, "page %d: node %d out of bounds", pgno, i)
+			}
+			child := uint64(nativeEndian.Uint32(nd)) | uint64(nativeEndian.Uint16(nd[nodeFlagsOff:]))<<32
+			err = v.page(t, child, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case flags&(pageBranch|pageLeaf|pageOverflow) == pageLeaf:
+		t.leaf++
+		if depth != int(t.rec.depth) && !t.badDepth {
+			t.badDepth = true
+			err = v.treeProblem(t, nil, "leaf page %d at depth %d, statistics report %d", pgno, depth, t.rec.depth)
+			if err != nil {
+				return err
+			}
+		}
+		return v.leaf(t, p, pgno, n)
+	}
+	return v.treeProblem(t, nil, "page %d is not a branch or leaf page", pgno)
+}
+
+// leaf checks the n entries of the leaf page or sub-page p, which is or is
+// stored in page pgno.
+func (v *verifier) leaf(t *tree, p []byte, pgno uint64, n int) error {
+	if nativeEndian.Uint16(p[pageFlagsOff:])&pageLeaf2 != 0 {
+		ksize := int(nativeEndian.Uint16(p[pagePadOff:]))
+		if pageHeaderSize+n*ksize > len(p) {
+			return v.treeProblem(t, nil, "page %d: keys out of bounds", pgno)
+		}
+		for i := 0; i < n; i++ {
+			off := pageHeaderSize + i*ksize
+			err := v.order(t, p[off:off+ksize])
+			if err != nil {
+				return err
+			}
+			t.entries++
+		}
+		return nil
+	}
+	for i := 0; i < n; i++ {
+		nd := node(p, n, i)
+		if nd == nil {
+			return v.treeProblem(t, nil, "page %d: node %d out of bounds", pgno, i)
+		}
+		err := v.entry(t, pgno, nd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// order checks that key sorts after the previous key of t.
+func (v *verifier) order(t *tree, key []byte) error {
+	prev := t.prev
+	started := t.started
+	t.prev, t.started = key, true
+	if t.cmp == nil || !started || t.cmp(prev, key) < 0 {
+		return nil
+	}
+	if t.dupKey != nil {
+		return v.treeProblem(t, nil, "duplicate out of order")
+	}
+	return v.treeProblem(t, key, "key out of order")
+}
+
+// entry checks the leaf node nd of page pgno.
+func (v *verifier) entry(t *tree, pgno uint64, nd []byte) error {
+	ksize := int(nativeEndian.Uint16(nd[nodeKeySizeOff:]))
+	key := nd[nodeSize : nodeSize+ksize]
+	flags := nativeEndian.Uint16(nd[nodeFlagsOff:])
+	size := uint64(nativeEndian.Uint32(nd))
+	err := v.order(t, key)
+	if err != nil {
+		return err
+	}
+	data := nd[nodeSize+ksize:]
+	inline := size
+	if flags&nodeBigData != 0 {
+		inline = 8
+	}
+	if inline > uint64(len(data)) {
+		return v.treeProblem(t, key, "page %d: value out of bounds", pgno)
+	}
+	data = data[:inline]
+
+	switch {
+	case t.dupKey != nil && flags != 0:
+		return v.treeProblem(t, nil, "invalid node flags %#x", flags)
+	case flags&nodeDupData != 0:
+		if uint(t.rec.flags)&DUPSORT == 0 {
+			return v.treeProblem(t, key, "duplicates in a database without DUPSORT")
+		}
+		dups := &tree{db: t.db, dupKey: key, cmp: t.dcmp}
+		if flags&nodeSubData != 0 {
+			if len(data) != dbRecordSize {
+				return v.treeProblem(t, key, "invalid sub-database record")
+			}
+			dups.rec = parseDBRecord(data)
+			err = v.walk(dups)
+		} else {
+			err = v.subPage(dups, pgno, data)
+		}
+		t.entries += dups.entries
+		return err
+	case flags&nodeSubData != 0:
+		if !t.main || len(data) != dbRecordSize {
+			return v.treeProblem(t, key, "invalid database record")
+		}
+		v.named = append(v.named, &tree{db: string(key), rec: parseDBRecord(data)})
+	case flags&nodeBigData != 0:
+		data, err = v.overflow(t, key, nativeEndian.Uint64(data), size)
+		if err != nil {
+			return err
+		}
+	}
+	t.entries++
+	if t.freeList && data != nil {
+		return v.freePages(t, key, data)
+	}
+	return nil
+}
+
+// subPage checks the duplicates stored in the node data p of page pgno.
+func (v *verifier) subPage(t *tree, pgno uint64, p []byte) error {
+	if len(p) < pageHeaderSize || nativeEndian.Uint16(p[pageFlagsOff:])&(pageLeaf|pageSub) != pageLeaf|pageSub {
+		return v.treeProblem(t, nil, "page %d: invalid sub-page", pgno)
+	}
+	n, ok := numKeys(p)
+	if !ok {
+		return v.treeProblem(t, nil, "page %d: sub-page has an invalid header", pgno)
+	}
+	return v.leaf(t, p, pgno, n)
+}
+
+// overflow claims the overflow pages starting at pgno holding the value of
+// key and returns the value, or nil if a problem was found.
+func (v *verifier) overflow(t *tree, key []byte, pgno, size uint64) ([]byte, error) {
+	n := (pageHeaderSize-1+size)/v.psize + 1
+	p, err := v.claim(t, pgno, n)
+	if p == nil {
+		return nil, err
+	}
+	t.overflow += n
+	if nativeEndian.Uint16(p[pageFlagsOff:]) != pageOverflow {
+		return nil, v.treeProblem(t, key, "page %d is not an overflow page", pgno)
+	}
+	if count := uint64(nativeEndian.Uint32(p[pageOverflowOff:])); count != n {
+		return nil, v.treeProblem(t, key, "overflow page %d holds %d pages, the value needs %d", pgno, count, n)
+	}
+	return p[pageHeaderSize : pageHeaderSize+size], nil
+}
+
+// freePages marks the pages of the page list val of the free list as free.
+func (v *verifier) freePages(t *tree, key, val []byte) error {
+	const idSize = 8
+	// Each entry is a page list starting with its length.
+	if len(val) < idSize || len(val)%idSize != 0 {
+		return v.treeProblem(t, key, "invalid page list size")
+	}
+	// Records may be reserved larger than their page list.
+	n := nativeEndian.Uint64(val)
+	if n > uint64(len(val)/idSize)-1 {
+		return v.treeProblem(t, key, "page list of length %d does not fit into %d bytes", n, len(val))
+	}
+	for i := uint64(1); i <= n; i++ {
+		pgno := nativeEndian.Uint64(val[i*idSize:])
+		var err error
+		switch {
+		case pgno < 2 || pgno >= uint64(len(v.pages)):
+			err = v.treeProblem(t, key, "free page %d out of range", pgno)
+		case v.pages[pgno] == pageUsed:
+			err = v.treeProblem(t, key, "page %d is used and free", pgno)
+		case v.pages[pgno] == pageFree:
+			err = v.treeProblem(t, key, "page %d is free twice", pgno)
+		default:
+			v.pages[pgno] = pageFree
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package mdb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	env := setupMaxDBs(t, 4)
+	defer clean(env, t)
+	err := env.Update(func(txn *Txn) error {
+		main, err := txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 500; i++ {
+			size := 10
+			if i%50 == 0 {
+				size = 10000
+			}
+			err = txn.Put(main, []byte(fmt.Sprintf("key%04d", i)), bytes.Repeat([]byte{'v'}, size), 0)
+			if err != nil {
+				return err
+			}
+		}
+		name := "dups"
+		dups, err := txn.DBIOpen(&name, CREATE|DUPSORT)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2000; i++ {
+			err = txn.Put(dups, []byte(fmt.Sprintf("k%d", i%3)), []byte(fmt.Sprintf("val%05d", i)), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot put: %s", err)
+	}
+	err = env.Update(func(txn *Txn) error {
+		main, err := txn.DBIOpen(nil, 0)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 500; i += 3 {
+			err = txn.Del(main, []byte(fmt.Sprintf("key%04d", i)), nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot delete: %s", err)
+	}
+
+	report, err := env.Verify(VerifyOptions{})
+	if err != nil {
+		t.Fatalf("Cannot verify: %s", err)
+	}
+	if !report.OK() {
+		t.Errorf("Problems: %v", report.Problems)
+	}
+	if report.DBs != 2 || report.Entries != 333+1+2000 {
+		t.Errorf("%d databases with %d entries", report.DBs, report.Entries)
+	}
+	if report.FreePages == 0 || report.UsedPages+report.FreePages != report.Pages {
+		t.Errorf("%d used and %d free pages of %d", report.UsedPages, report.FreePages, report.Pages)
+	}
+
+	// Keys stored in the default order are out of order for a reversed
+	// comparator.
+	err = env.Update(func(txn *Txn) error {
+		name := "dups"
+		dbi, err := txn.DBIOpen(&name, 0)
+		if err != nil {
+			return err
+		}
+		return txn.SetCompare(dbi, func(a, b []byte) int { return bytes.Compare(b, a) })
+	})
+	if err != nil {
+		t.Fatalf("Cannot set comparator: %s", err)
+	}
+	report, err = env.Verify(VerifyOptions{MaxProblems: 1})
+	if err != nil {
+		t.Fatalf("Cannot verify: %s", err)
+	}
+	if len(report.Problems) != 1 || !report.Truncated || report.Problems[0].String() != "database dups: key 6b31: key out of order" {
+		t.Errorf("Problems: %v", report.Problems)
+	}
+	report, err = env.Verify(VerifyOptions{SkipOrder: true})
+	if err != nil {
+		t.Fatalf("Cannot verify: %s", err)
+	}
+	if !report.OK() {
+		t.Errorf("Problems: %v", report.Problems)
+	}
+}
+
+func TestVerifyCorrupt(t *testing.T) {
+	env := setupMaxDBs(t, 1)
+	defer clean(env, t)
+	putRange(t, env, 0, 1000)
+	putRange(t, env, 0, 500)
+	err := env.Update(func(txn *Txn) error {
+		name := "dups"
+		dbi, err := txn.DBIOpen(&name, CREATE|DUPSORT)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10; i++ {
+			err = txn.Put(dbi, []byte("k"), []byte{byte(i)}, 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cannot put: %s", err)
+	}
+	path, err := env.Path()
+	if err != nil {
+		t.Fatalf("Cannot get path: %s", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(path, "data.mdb"))
+	if err != nil {
+		t.Fatalf("Cannot read data file: %s", err)
+	}
+	psize := uint64(nativeEndian.Uint32(data[metaPSizeOff:]))
+	meta := data[:psize]
+	if nativeEndian.Uint64(data[psize+metaTxnIDOff:]) > nativeEndian.Uint64(meta[metaTxnIDOff:]) {
+		meta = data[psize : 2*psize]
+	}
+	stat := func(dbi DBI, off int) uint64 {
+		return nativeEndian.Uint64(meta[metaDBOff(dbi)+off:])
+	}
+	root := stat(mainDBI, dbRootOff)
+	rootPage := data[root*psize : (root+1)*psize]
+	if nativeEndian.Uint16(rootPage[pageFlagsOff:])&pageBranch == 0 {
+		t.Fatalf("Root page %d is not a branch page", root)
+	}
+	// The nodes of the root page, whose first six bytes hold the child page
+	// number.
+	node0 := rootPage[nativeEndian.Uint16(rootPage[pageHeaderSize:]):]
+	node1 := rootPage[nativeEndian.Uint16(rootPage[pageHeaderSize+2:]):]
+	child0 := uint64(nativeEndian.Uint32(node0)) | uint64(nativeEndian.Uint16(node0[nodeFlagsOff:]))<<32
+	rootOff := int(root * psize)
+
+	// corruptStat adds 1 to a statistic of dbi in both meta pages.
+	corruptStat := func(dbi DBI, off int) func([]byte) {
+		return func(data []byte) {
+			for _, meta := range []int{0, int(psize)} {
+				p := data[meta+metaDBOff(dbi)+off:]
+				nativeEndian.PutUint64(p, nativeEndian.Uint64(p)+1)
+			}
+		}
+	}
+	for _, test := range []struct {
+		name    string
+		corrupt func([]byte)
+		problem string
+	}{
+		{"none", func([]byte) {}, ""},
+		{"main entries", corruptStat(mainDBI, dbEntriesOff), fmt.Sprintf(
+			"main database: %d entries found, statistics report %d", stat(mainDBI, dbEntriesOff), stat(mainDBI, dbEntriesOff)+1)},
+		{"main leaf pages", corruptStat(mainDBI, dbLeafOff), fmt.Sprintf(
+			"main database: %d leaf pages found, statistics report %d", stat(mainDBI, dbLeafOff), stat(mainDBI, dbLeafOff)+1)},
+		{"free entries", corruptStat(FREE_DBI, dbEntriesOff), fmt.Sprintf(
+			"free list: %d entries found, statistics report %d", stat(FREE_DBI, dbEntriesOff), stat(FREE_DBI, dbEntriesOff)+1)},
+		{"page number", func(data []byte) {
+			nativeEndian.PutUint64(data[rootOff+pageNumberOff:], root+1)
+		}, fmt.Sprintf("main database: page %d has the number %d", root, root+1)},
+		{"shared page", func(data []byte) {
+			copy(data[rootOff+len(rootPage)-len(node1):], node0[:nodeKeySizeOff])
+		}, fmt.Sprintf("main database: page %d is used twice", child0)},
+	} {
+		corrupt := append([]byte(nil), data...)
+		test.corrupt(corrupt)
+		dir, err := ioutil.TempDir("/tmp", "mdb_test")
+		if err != nil {
+			t.Fatalf("Cannot create temporary directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+		err = ioutil.WriteFile(filepath.Join(dir, "data.mdb"), corrupt, 0664)
+		if err != nil {
+			t.Fatalf("Cannot write data file: %s", err)
+		}
+		corrupted, err := NewEnv()
+		if err != nil {
+			t.Fatalf("Cannot create environment: %s", err)
+		}
+		// Verify opens no handles, so it needs neither write access nor
+		// room for the named database.
+		err = corrupted.Open(dir, RDONLY, 0664)
+		if err != nil {
+			t.Fatalf("Cannot open environment: %s", err)
+		}
+		report, err := corrupted.Verify(VerifyOptions{})
+		corrupted.Close()
+		if err != nil {
+			t.Fatalf("Cannot verify: %s", err)
+		}
+		switch {
+		case test.problem == "":
+			if !report.OK() || report.DBs != 2 || report.Entries != 1000+1+10 {
+				t.Errorf("%s: %d databases with %d entries, problems %v", test.name, report.DBs, report.Entries, report.Problems)
+			}
+		case report.OK() || report.Problems[0].String() != test.problem:
+			t.Errorf("%s: problems %v", test.name, report.Problems)
+		}
+	}
+}